@@ -0,0 +1,260 @@
+package dandler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WithArchiveLimits sets the most an archive may hold - the total size of the
+// files in it, and how many files there are. Directories over either limit
+// are refused rather than sent. A limit of 0 or less removes it.
+func WithArchiveLimits(maxBytes int64, maxFiles int) Option {
+	return func(o *options) {
+		o.archiveBytes = maxBytes
+		o.archiveFiles = maxFiles
+	}
+}
+
+// WithDownloads lets Index send each directory as an archive, when asked for
+// with ?download - and has the built-in templates link to them. Archives can
+// be large, so Index does not send them unless told to.
+func WithDownloads() Option {
+	return func(o *options) {
+		o.downloads = true
+	}
+}
+
+// Archive sends the requested directory - and everything below it - as a
+// single download. The format is picked with ?download=zip (the default) or
+// ?download=tar.gz, and the archive is streamed as it is built, so nothing is
+// buffered to disk. Ignored paths are left out, as with Index - see
+// WithIgnore - and symlinks are followed as WithSymlinks allows. Anything else
+// that is not a regular file is left out.
+//
+// Directories larger than the limits set with WithArchiveLimits are refused.
+// Index also serves archives, when ?download is given - see WithDownloads.
+func Archive(logger *log.Logger, basepath string, opts ...Option) http.Handler {
+	o := buildOptions(opts)
+	return archiveHandler{basePath: basepath, l: logger, maxBytes: o.archiveBytes, maxFiles: o.archiveFiles,
+		ignore: newListIgnorer(http.Dir(basepath), o), symlinks: o.symlinks}
+}
+
+type archiveHandler struct {
+	basePath string
+	l        *log.Logger
+	maxBytes int64
+	maxFiles int
+	ignore   *ignorer
+	symlinks SymlinkPolicy
+}
+
+// errTooLarge is returned when a directory holds more than an archive may.
+var errTooLarge = errors.New("too large to archive")
+
+// archiveFile is a file to be added to an archive, relative to the directory
+// being archived.
+type archiveFile struct {
+	name     string
+	location string
+}
+
+func (h archiveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("download")
+	switch format {
+	case "":
+		format = "zip"
+	case "zip", "tar.gz":
+	case "tgz":
+		format = "tar.gz"
+	default:
+		http.Error(w, fmt.Sprintf("unknown archive format: %s", format), http.StatusBadRequest)
+		h.l.Printf("400 - unknown archive format: %s", format)
+		return
+	}
+
+	dirPath := path.Clean("/" + r.URL.Path)
+	root, stat, err := h.symlinks.resolve(h.basePath, dirPath)
+	if err == nil && h.ignore.ignored(dirPath, stat.IsDir()) {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("not found: %s", r.URL.Path), http.StatusNotFound)
+		h.l.Printf("404 - could not find directory: %s - %s", filepath.Join(h.basePath, dirPath), err)
+		return
+	}
+	if !stat.IsDir() {
+		http.Error(w, fmt.Sprintf("cannot read target: %s", r.URL.Path), http.StatusForbidden)
+		h.l.Printf("403 - not a directory: %s", root)
+		return
+	}
+
+	// everything is found up front, so the limits can be checked before any
+	// of the response is sent
+	files, err := h.collect(root, dirPath)
+	if errors.Is(err, errTooLarge) {
+		http.Error(w, fmt.Sprintf("directory too large to download: %s", r.URL.Path), http.StatusForbidden)
+		h.l.Printf("403 - could not archive directory: %s - %s", root, err)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot read directory: %s", r.URL.Path), http.StatusForbidden)
+		h.l.Printf("403 - could not archive directory: %s - %s", root, err)
+		return
+	}
+
+	name := path.Base(dirPath)
+	if name == "/" {
+		name = "archive"
+	}
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": name + "." + format}))
+
+	out := ctxWriter{ctx: r.Context(), w: w}
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		err = writeZip(out, name, files)
+	} else {
+		w.Header().Set("Content-Type", "application/gzip")
+		err = writeTarGz(out, name, files)
+	}
+	if err != nil {
+		// the response has already started, so all that can be done is to stop
+		h.l.Printf("archive of %s stopped part way - %s", root, err)
+	}
+}
+
+// collect lists every file to be archived below root - found at dirPath in the
+// request - failing if there are more than the limits allow. Symlinks are
+// followed as WithSymlinks allows, but never back into a directory already
+// being archived.
+func (h archiveHandler) collect(root, dirPath string) ([]archiveFile, error) {
+	var files []archiveFile
+	var total int64
+
+	var walk func(location, urlPath string, ancestors []os.FileInfo) error
+	walk = func(location, urlPath string, ancestors []os.FileInfo) error {
+		f, err := os.Open(location)
+		if err != nil {
+			return err
+		}
+		contents, err := f.Readdir(0)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		sort.Slice(contents, func(i, j int) bool { return contents[i].Name() < contents[j].Name() })
+
+		scope := h.ignore.in(urlPath)
+		for _, each := range contents {
+			entryPath := path.Join(urlPath, each.Name())
+			entryLocation, info, ok := h.symlinks.resolveEntry(h.basePath, urlPath, each)
+			if !ok || scope.ignored(each.Name(), info.IsDir()) {
+				continue
+			}
+			if info.IsDir() {
+				if isLoop(ancestors, info) {
+					continue
+				}
+				if err := walk(entryLocation, entryPath, append(ancestors, info)); err != nil {
+					return err
+				}
+				continue
+			}
+			if !info.Mode().IsRegular() {
+				continue
+			}
+
+			rel := strings.TrimPrefix(strings.TrimPrefix(entryPath, dirPath), "/")
+			files = append(files, archiveFile{name: rel, location: entryLocation})
+			total += info.Size()
+			if h.maxFiles > 0 && len(files) > h.maxFiles {
+				return fmt.Errorf("%w - more than %d files", errTooLarge, h.maxFiles)
+			}
+			if h.maxBytes > 0 && total > h.maxBytes {
+				return fmt.Errorf("%w - more than %d bytes", errTooLarge, h.maxBytes)
+			}
+		}
+		return nil
+	}
+
+	stat, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	return files, walk(root, dirPath, []os.FileInfo{stat})
+}
+
+func writeZip(w io.Writer, prefix string, files []archiveFile) error {
+	zw := zip.NewWriter(w)
+	for _, each := range files {
+		err := addFile(each, func(info os.FileInfo) (io.Writer, error) {
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return nil, err
+			}
+			header.Name = path.Join(prefix, each.name)
+			header.Method = zip.Deflate
+			return zw.CreateHeader(header)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeTarGz(w io.Writer, prefix string, files []archiveFile) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, each := range files {
+		err := addFile(each, func(info os.FileInfo) (io.Writer, error) {
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return nil, err
+			}
+			header.Name = path.Join(prefix, each.name)
+			return tw, tw.WriteHeader(header)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// addFile copies a file into an archive. create starts the entry for the file,
+// and returns where its contents go. Exactly the size given to create is
+// copied, in case the file changes while it is being read.
+func addFile(file archiveFile, create func(os.FileInfo) (io.Writer, error)) error {
+	f, err := os.Open(file.location)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := create(info)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(dst, f, info.Size())
+	return err
+}
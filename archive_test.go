@@ -0,0 +1,169 @@
+package dandler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func archiveFixture(t *testing.T) string {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	for name, content := range map[string]string{
+		"gallery/a.txt":            "aaaa",
+		"gallery/deeper/b.txt":     "bb",
+		"gallery/.hidden":          "secret",
+		"gallery/.git/config":      "secret",
+		"gallery/deeper/.DS_Store": "secret",
+	} {
+		location := filepath.Join(tempdir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(location), 0755))
+		require.NoError(t, ioutil.WriteFile(location, []byte(content), 0644))
+	}
+	return tempdir
+}
+
+func readZip(t *testing.T, body []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+	files := make(map[string]string)
+	for _, each := range zr.File {
+		f, err := each.Open()
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(f)
+		f.Close()
+		require.NoError(t, err)
+		files[each.Name] = string(content)
+	}
+	return files
+}
+
+func readTarGz(t *testing.T, body []byte) map[string]string {
+	gr, err := gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+	files := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		files[header.Name] = string(content)
+	}
+	return files
+}
+
+func TestArchive(t *testing.T) {
+	tempdir := archiveFixture(t)
+	defer os.RemoveAll(tempdir)
+	logger := log.New(ioutil.Discard, "", 0)
+	expected := map[string]string{"gallery/a.txt": "aaaa", "gallery/deeper/b.txt": "bb"}
+
+	var testData = []struct {
+		uri         string
+		opts        []Option
+		code        int
+		contentType string
+		read        func(*testing.T, []byte) map[string]string
+	}{
+		{uri: "/gallery/", code: 200, contentType: "application/zip", read: readZip},
+		{uri: "/gallery/?download=zip", code: 200, contentType: "application/zip", read: readZip},
+		{uri: "/gallery/?download=tar.gz", code: 200, contentType: "application/gzip", read: readTarGz},
+		{uri: "/gallery/?download=tgz", code: 200, contentType: "application/gzip", read: readTarGz},
+		{uri: "/gallery/?download=rar", code: 400},
+		{uri: "/missing/", code: 404},
+		{uri: "/gallery/a.txt", code: 403},
+		{uri: "/gallery/", opts: []Option{WithArchiveLimits(0, 1)}, code: 403},
+		{uri: "/gallery/", opts: []Option{WithArchiveLimits(5, 0)}, code: 403},
+		{uri: "/gallery/", opts: []Option{WithArchiveLimits(6, 2)}, code: 200,
+			contentType: "application/zip", read: readZip},
+	}
+
+	for _, test := range testData {
+		t.Run(test.uri, func(t *testing.T) {
+			ts := httptest.NewServer(Archive(logger, tempdir, test.opts...))
+			defer ts.Close()
+
+			res, err := http.Get(ts.URL + test.uri)
+			require.NoError(t, err)
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, test.code, res.StatusCode)
+			if test.read == nil {
+				return
+			}
+			assert.Equal(t, test.contentType, res.Header.Get("Content-Type"))
+			assert.Contains(t, res.Header.Get("Content-Disposition"), `filename=gallery.`)
+			assert.Equal(t, expected, test.read(t, body))
+		})
+	}
+}
+
+func TestIndex_download(t *testing.T) {
+	tempdir := archiveFixture(t)
+	defer os.RemoveAll(tempdir)
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Index(logger, tempdir, done, nil, WithDownloads()))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/gallery/?download=zip")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var names []string
+	for name := range readZip(t, body) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"gallery/a.txt", "gallery/deeper/b.txt"}, names)
+
+	res, err = http.Get(ts.URL + "/gallery/")
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `<a href="?download=zip">zip</a>`)
+}
+
+func TestIndex_noDownloads(t *testing.T) {
+	tempdir := archiveFixture(t)
+	defer os.RemoveAll(tempdir)
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Index(logger, tempdir, done, nil))
+	defer ts.Close()
+
+	// without WithDownloads, the listing is sent - and does not link to archives
+	res, err := http.Get(ts.URL + "/gallery/?download=zip")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, res.Header.Get("Content-Type"), "text/html")
+	assert.NotContains(t, string(body), "?download=")
+}
@@ -0,0 +1,57 @@
+package dandler
+
+import (
+	"context"
+	"image"
+	"io"
+
+	"github.com/nfnt/resize"
+)
+
+// ctxReader stops reading once its context is cancelled. Image decoders read
+// a few rows at a time, so wrapping their input lets a decode stop part way
+// through a large image.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// ctxWriter stops writing once its context is cancelled, so that encoding
+// stops part way through a large image.
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c ctxWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
+}
+
+// resizeImage is what resizeHeight uses to scale - replaced in tests.
+var resizeImage = resize.Resize
+
+// resizeHeight scales img to the given height. resize.Resize cannot be
+// stopped part way, so ctx is only checked before and after. The resize runs
+// within the job that called it, so that job keeps its WorkPool worker until
+// the resize is really done - a cancelled request never leaves work running
+// outside the pool.
+func resizeHeight(ctx context.Context, height uint, img image.Image) (image.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	shrunk := resizeImage(0, height, img, resize.MitchellNetravali)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return shrunk, nil
+}
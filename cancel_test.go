@@ -0,0 +1,124 @@
+package dandler
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nfnt/resize"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCtxReaderWriter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r := ctxReader{ctx: ctx, r: strings.NewReader("ohai")}
+	buf := make([]byte, 2)
+	n, err := r.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	var out bytes.Buffer
+	w := ctxWriter{ctx: ctx, w: &out}
+	n, err = w.Write([]byte("ohai"))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	cancel()
+	_, err = r.Read(buf)
+	assert.Equal(t, context.Canceled, err)
+	_, err = w.Write([]byte("more"))
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, "ohai", out.String())
+}
+
+func TestResizeHeight(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	shrunk, err := resizeHeight(context.Background(), 100, img)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 200, 100), shrunk.Bounds())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = resizeHeight(ctx, 100, img)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestResizeHeight_holdsWorker(t *testing.T) {
+	defer func(original func(uint, uint, image.Image, resize.InterpolationFunction) image.Image) {
+		resizeImage = original
+	}(resizeImage)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	resizeImage = func(w, h uint, img image.Image, interp resize.InterpolationFunction) image.Image {
+		close(started)
+		<-release
+		return img
+	}
+
+	pool := NewWorkPool(1, 0, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() {
+		finished <- pool.Do(ctx, func(ctx context.Context) error {
+			_, err := resizeHeight(ctx, 100, image.NewRGBA(image.Rect(0, 0, 400, 200)))
+			return err
+		})
+	}()
+	<-started
+
+	// cancelling does not free the worker while the resize is still running
+	cancel()
+	select {
+	case err := <-finished:
+		t.Fatalf("job returned before the resize finished - %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, ErrSaturated, pool.Do(context.Background(), func(context.Context) error { return nil }))
+
+	close(release)
+	assert.Equal(t, context.Canceled, <-finished)
+	assert.NoError(t, pool.Do(context.Background(), func(context.Context) error { return nil }))
+}
+
+func TestThumbCache_cancelled(t *testing.T) {
+	h := thumbCache{x: 300, y: 250, raw: "testdata", thumbExt: "png"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := h.generateThumbnail(ctx, "lemur_pudding_cups.jpg")
+	assert.Equal(t, context.Canceled, err)
+
+	data, err := h.generateThumbnail(context.Background(), "lemur_pudding_cups.jpg")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, data)
+}
+
+func TestThumbnail_cancelled(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+
+	h := thumbnailHandler{x: 200, y: 200, raw: "testdata", thumbExt: "png", thumbs: tempdir}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = h.loadThumbnail(ctx, "lemur_pudding_cups.jpg")
+	assert.Equal(t, context.Canceled, err)
+
+	// nothing - not even a partial file - should be left behind
+	files, err := ioutil.ReadDir(tempdir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	_, err = h.loadThumbnail(context.Background(), "lemur_pudding_cups.jpg")
+	assert.NoError(t, err)
+	_, err = os.Stat(h.generateThumbPath("lemur_pudding_cups.jpg"))
+	assert.NoError(t, err)
+}
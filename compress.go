@@ -0,0 +1,226 @@
+package dandler
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressEncodings are the encodings Compress can send, most preferred
+// first.
+var compressEncodings = []string{"br", "gzip", "deflate"}
+
+// Compress compresses responses from child with brotli, gzip or deflate - as
+// picked from Accept-Encoding. Responses smaller than minSize, responses that
+// are already encoded - such as precompressed files from ContentType - and
+// content types that are compressed already, such as most images, video and
+// archives, are sent as they are.
+//
+// Responses are held until minSize bytes have been written, or child flushes -
+// after that, everything is compressed as it is written, so streams such as
+// IndexEvents keep working. Range requests are never compressed.
+func Compress(minSize int, child http.Handler) http.Handler {
+	return compressHandler{minSize: minSize, child: child}
+}
+
+type compressHandler struct {
+	minSize int
+	child   http.Handler
+}
+
+func (h compressHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cw := &compressWriter{ResponseWriter: w, minSize: h.minSize}
+	if r.Method != http.MethodHead && r.Header.Get("Range") == "" {
+		cw.encoding = negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	}
+	defer cw.close()
+	h.child.ServeHTTP(cw, r)
+}
+
+// negotiateEncoding picks the encoding to compress with, or "" if the client
+// did not ask for any.
+func negotiateEncoding(accept string) string {
+	// without Accept-Encoding, anything is allowed - but the client most
+	// likely cannot decompress at all
+	if strings.TrimSpace(accept) == "" {
+		return ""
+	}
+	best, bestQ := "", 0.0
+	for _, encoding := range compressEncodings {
+		if q := acceptQuality(accept, encoding); q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressibleType reports if a response of the given Content-Type is worth
+// compressing.
+func compressibleType(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	switch mediaType {
+	case "image/svg+xml":
+		return true
+	case "application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+		"application/x-bzip2", "application/x-xz", "application/x-7z-compressed",
+		"application/x-rar-compressed", "application/pdf", "application/ogg",
+		"application/octet-stream", "font/woff", "font/woff2":
+		return false
+	}
+	for _, prefix := range []string{"image/", "video/", "audio/"} {
+		if strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// flushWriter is an encoder that can send what it has so far.
+type flushWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressWriter holds the response until it knows whether to compress it,
+// then either compresses everything written or passes it through.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	code    int
+	buf     []byte
+	decided bool
+	enc     flushWriter
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.code = code
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.minSize {
+			return len(p), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush sends everything written so far - the response is compressed from
+// here on, if it can be, as more is likely to follow.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// decide sends the headers - set up for compression if big is set and the
+// response can be compressed - followed by anything held so far.
+func (w *compressWriter) decide(big bool) error {
+	w.decided = true
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	h := w.Header()
+	if _, ok := h["Content-Type"]; !ok && len(w.buf) > 0 {
+		// sniffing after compression would only see the compressed bytes
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	addVary(h, "Accept-Encoding")
+
+	if big && w.encoding != "" && w.compressible() {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+		// the compressed response is not byte for byte the same
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		w.enc = newEncoder(w.encoding, w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+
+	held := w.buf
+	w.buf = nil
+	if len(held) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(held)
+	} else {
+		_, err = w.ResponseWriter.Write(held)
+	}
+	return err
+}
+
+func (w *compressWriter) compressible() bool {
+	h := w.Header()
+	if w.code < 200 || w.code == http.StatusNoContent || w.code == http.StatusNotModified ||
+		w.code == http.StatusPartialContent {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	return compressibleType(h.Get("Content-Type"))
+}
+
+// close finishes the response - anything still held was too small to be
+// worth compressing.
+func (w *compressWriter) close() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.enc != nil {
+		w.enc.Close()
+	}
+}
+
+func newEncoder(encoding string, w io.Writer) flushWriter {
+	switch encoding {
+	case "br":
+		return brotli.NewWriter(w)
+	case "deflate":
+		return zlib.NewWriter(w)
+	default:
+		return gzip.NewWriter(w)
+	}
+}
+
+// addVary adds value to the Vary header, unless it is already there.
+func addVary(h http.Header, value string) {
+	for _, line := range h["Vary"] {
+		for _, each := range strings.Split(line, ",") {
+			if strings.EqualFold(strings.TrimSpace(each), value) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", value)
+}
@@ -0,0 +1,149 @@
+package dandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decompress(t *testing.T, encoding string, body []byte) string {
+	var r io.Reader
+	var err error
+	switch encoding {
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		r = bytes.NewReader(body)
+	}
+	require.NoError(t, err)
+	out, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	for accept, expected := range map[string]string{
+		"":                    "",
+		"identity":            "",
+		"gzip":                "gzip",
+		"gzip, deflate, br":   "br",
+		"br;q=0.5, deflate":   "deflate",
+		"*":                   "br",
+		"*, br;q=0":           "gzip",
+		"compress, x-unknown": "",
+	} {
+		assert.Equal(t, expected, negotiateEncoding(accept), accept)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	text := strings.Repeat("all work and no play makes jack a dull boy\n", 100)
+	var testData = []struct {
+		handler  http.Handler
+		accept   string
+		encoding string
+		body     string
+	}{
+		{handler: Success(text), accept: "gzip", encoding: "gzip", body: text},
+		{handler: Success(text), accept: "gzip, deflate, br", encoding: "br", body: text},
+		{handler: Success(text), accept: "deflate", encoding: "deflate", body: text},
+		{handler: Success(text), accept: "", encoding: "", body: text},
+		{handler: Success("ohai"), accept: "gzip", encoding: "", body: "ohai"},
+		{handler: ResponseCode(404, "not here"), accept: "gzip", encoding: "", body: "not here\n"},
+		{handler: ResponseCode(500, text), accept: "gzip", encoding: "gzip", body: text + "\n"},
+		{handler: Header("Content-Type", "image/png", Success(text)), accept: "gzip", encoding: "", body: text},
+		{handler: Header("Content-Encoding", "zstd", Success(text)), accept: "gzip", encoding: "zstd", body: text},
+	}
+	for id, test := range testData {
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.accept != "" {
+			req.Header.Set("Accept-Encoding", test.accept)
+		}
+		w := httptest.NewRecorder()
+		Compress(256, test.handler).ServeHTTP(w, req)
+
+		assert.Equal(t, test.encoding, w.Header().Get("Content-Encoding"), "test %d", id)
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"), "test %d", id)
+		if test.encoding == "zstd" {
+			assert.Equal(t, test.body, w.Body.String(), "test %d", id)
+			continue
+		}
+		assert.Equal(t, test.body, decompress(t, test.encoding, w.Body.Bytes()), "test %d", id)
+		assert.NotEqual(t, "application/x-gzip", w.Header().Get("Content-Type"), "test %d", id)
+	}
+}
+
+func TestCompress_flush(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: one\n\n")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "data: two\n\n")
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	Compress(1024, handler).ServeHTTP(w, req)
+
+	assert.True(t, w.Flushed)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "data: one\n\ndata: two\n\n", decompress(t, "gzip", w.Body.Bytes()))
+}
+
+func TestCompress_composes(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	mux := http.NewServeMux()
+	mux.Handle("/index/", http.StripPrefix("/index", Index(logger, "testdata/sample_images", done, nil)))
+	mux.Handle("/files/", http.StripPrefix("/files", ContentType(logger, "testdata")))
+	ts := httptest.NewServer(Compress(256, mux))
+	defer ts.Close()
+
+	get := func(uri, rangeHeader string) *http.Response {
+		req, err := http.NewRequest("GET", ts.URL+uri, nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept-Encoding", "gzip")
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		res, err := http.DefaultTransport.RoundTrip(req)
+		require.NoError(t, err)
+		return res
+	}
+
+	res := get("/index/", "")
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, []string{"Accept", "Accept-Encoding"}, res.Header["Vary"])
+	assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(res.Header.Get("ETag"), `W/"`))
+	assert.Contains(t, decompress(t, "gzip", body), "<html")
+
+	res = get("/files/blocked_us.png", "")
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Empty(t, res.Header.Get("Content-Encoding"), "images are left alone")
+	assert.Equal(t, "image/jpeg", res.Header.Get("Content-Type"))
+
+	res = get("/index/", "bytes=0-9")
+	res.Body.Close()
+	assert.Empty(t, res.Header.Get("Content-Encoding"), "ranges are left alone")
+}
@@ -0,0 +1,189 @@
+package dandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// ContentTypeDetector guesses the content type of a file from its name and
+// the first 512 bytes of it. It returns "" if it cannot tell, so that the next
+// detector can be tried.
+type ContentTypeDetector func(name string, head []byte) string
+
+// WithContentTypeDetection sets how ContentType picks the Content-Type of a
+// file - each detector is tried in turn, and the first answer is used. If none
+// of them can tell, the file is sniffed with http.DetectContentType, which is
+// also all that is done by default.
+//
+// Detectors of your own can be mixed in anywhere, for example:
+//
+//	WithContentTypeDetection(myDetector, DetectSignature, DetectExtension)
+func WithContentTypeDetection(detectors ...ContentTypeDetector) Option {
+	return func(o *options) {
+		o.detectors = detectors
+	}
+}
+
+// HybridDetection trusts the content of a file first - so that files with the
+// wrong extension are still served correctly - and falls back to the
+// extension for text formats sniffing cannot tell apart, such as CSS and
+// JavaScript. JSON is only picked from the content when the extension does not
+// say otherwise, as a script can start just like it.
+var HybridDetection = []ContentTypeDetector{DetectSignature, DetectSniff, DetectExtension, DetectJSON}
+
+// detectContentType runs each detector in turn, sniffing if none can tell.
+func detectContentType(detectors []ContentTypeDetector, name string, head []byte) string {
+	for _, detect := range detectors {
+		if contentType := detect(name, head); contentType != "" {
+			return contentType
+		}
+	}
+	return http.DetectContentType(head)
+}
+
+// extensionTypes are used before the system's MIME database, so that common
+// web formats get the same type everywhere.
+var extensionTypes = map[string]string{
+	".css":         "text/css; charset=utf-8",
+	".js":          "text/javascript; charset=utf-8",
+	".mjs":         "text/javascript; charset=utf-8",
+	".json":        "application/json",
+	".map":         "application/json",
+	".webmanifest": "application/manifest+json",
+	".svg":         "image/svg+xml",
+	".webp":        "image/webp",
+	".avif":        "image/avif",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".wasm":        "application/wasm",
+	".md":          "text/markdown; charset=utf-8",
+}
+
+// DetectExtension picks the content type from the file's extension.
+func DetectExtension(name string, head []byte) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if contentType, ok := extensionTypes[ext]; ok {
+		return contentType
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// DetectSniff sniffs the content with http.DetectContentType, but only
+// answers when that finds something specific - not just text or binary.
+func DetectSniff(name string, head []byte) string {
+	contentType := http.DetectContentType(head)
+	switch contentType {
+	case "text/plain; charset=utf-8", "application/octet-stream":
+		return ""
+	}
+	return contentType
+}
+
+// Signature recognizes a file format from its content. Either Magic must be
+// found at Offset, or Match must return true.
+type Signature struct {
+	ContentType string
+	Offset      int
+	Magic       []byte
+	Match       func(head []byte) bool
+}
+
+func (s Signature) matches(head []byte) bool {
+	if s.Match != nil {
+		return s.Match(head)
+	}
+	return len(s.Magic) > 0 && len(head) >= s.Offset+len(s.Magic) &&
+		bytes.Equal(head[s.Offset:s.Offset+len(s.Magic)], s.Magic)
+}
+
+// DefaultSignatures are the formats DetectSignature knows - mostly those
+// http.DetectContentType does not.
+var DefaultSignatures = []Signature{
+	{ContentType: "font/woff2", Magic: []byte("wOF2")},
+	{ContentType: "font/woff", Magic: []byte("wOFF")},
+	{ContentType: "application/wasm", Magic: []byte("\x00asm")},
+	{ContentType: "image/avif", Offset: 4, Magic: []byte("ftypavif")},
+	{ContentType: "image/heic", Offset: 4, Magic: []byte("ftypheic")},
+	{ContentType: "application/zstd", Magic: []byte("\x28\xb5\x2f\xfd")},
+	{ContentType: "application/x-xz", Magic: []byte("\xfd7zXZ\x00")},
+	{ContentType: "application/x-7z-compressed", Magic: []byte("7z\xbc\xaf\x27\x1c")},
+	{ContentType: "image/webp", Match: func(head []byte) bool {
+		return len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP"))
+	}},
+	{ContentType: "image/svg+xml", Match: isSVG},
+}
+
+// Signatures returns a detector that recognizes the given formats, in order.
+// To add to the formats known, pass DefaultSignatures along with your own.
+func Signatures(signatures ...Signature) ContentTypeDetector {
+	return func(name string, head []byte) string {
+		for _, signature := range signatures {
+			if signature.matches(head) {
+				return signature.ContentType
+			}
+		}
+		return ""
+	}
+}
+
+// DetectSignature recognizes the formats in DefaultSignatures.
+func DetectSignature(name string, head []byte) string {
+	return Signatures(DefaultSignatures...)(name, head)
+}
+
+// DetectJSON recognizes a JSON object or array from its content. JavaScript
+// can start the same way, so this is best tried after DetectExtension.
+func DetectJSON(name string, head []byte) string {
+	if isJSON(head) {
+		return "application/json"
+	}
+	return ""
+}
+
+// trimText drops a byte order mark, whitespace and any padding after the
+// content.
+func trimText(head []byte) []byte {
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	return bytes.TrimSpace(bytes.TrimRight(head, "\x00"))
+}
+
+// isSVG reports if head starts an SVG document - possibly after an XML
+// declaration, a doctype or comments.
+func isSVG(head []byte) bool {
+	text := bytes.ToLower(trimText(head))
+	if !bytes.HasPrefix(text, []byte("<")) {
+		return false
+	}
+	i := bytes.Index(text, []byte("<svg"))
+	if i < 0 {
+		return false
+	}
+	before := text[:i]
+	return !bytes.Contains(before, []byte("<html")) &&
+		(i == 0 || bytes.HasPrefix(before, []byte("<?xml")) || bytes.HasPrefix(before, []byte("<!")))
+}
+
+// isJSON reports if head starts a JSON object or array. As only the start of
+// the file is seen, the first few tokens have to do.
+func isJSON(head []byte) bool {
+	text := trimText(head)
+	if len(text) == 0 || (text[0] != '{' && text[0] != '[') {
+		return false
+	}
+	dec := json.NewDecoder(bytes.NewReader(text))
+	for i := 0; i < 4; i++ {
+		if _, err := dec.Token(); err != nil {
+			// running out of content is fine, anything else is not JSON
+			_, syntax := err.(*json.SyntaxError)
+			return i > 0 && !syntax
+		}
+	}
+	return true
+}
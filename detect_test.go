@@ -0,0 +1,96 @@
+package dandler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectSignature(t *testing.T) {
+	for content, expected := range map[string]string{
+		"wOF2\x00\x01":                             "font/woff2",
+		"\x00asm\x01\x00\x00\x00":                  "application/wasm",
+		"RIFF\x10\x00\x00\x00WEBPVP8 ":             "image/webp",
+		"\x00\x00\x00\x1cftypavif":                 "image/avif",
+		`<svg xmlns="http://www.w3.org/2000/svg">`: "image/svg+xml",
+		"\xef\xbb\xbf<?xml version=\"1.0\"?>\n<!-- drawn by hand -->\n<svg>": "image/svg+xml",
+		"<!DOCTYPE html><html><body><svg></svg>":                             "",
+		`{"name": "dandler", "version": 1}`:                                  "",
+		"body { color: red; }":                                               "",
+		"":                                                                   "",
+	} {
+		assert.Equal(t, expected, DetectSignature("", []byte(content)), "%q", content)
+	}
+
+	custom := Signatures(append([]Signature{{ContentType: "application/x-dandler", Magic: []byte("DNDL")}},
+		DefaultSignatures...)...)
+	assert.Equal(t, "application/x-dandler", custom("", []byte("DNDL\x01")))
+	assert.Equal(t, "font/woff2", custom("", []byte("wOF2")))
+}
+
+func TestDetectJSON(t *testing.T) {
+	for content, expected := range map[string]string{
+		`{"name": "dandler", "version": 1}`:      "application/json",
+		"[\n  1, 2, 3":                           "application/json",
+		`{"truncated": [1, 2, ` + "\x00\x00\x00": "application/json",
+		"[INFO] not json at all":                 "",
+		"body { color: red; }":                   "",
+		"":                                       "",
+	} {
+		assert.Equal(t, expected, DetectJSON("", []byte(content)), "%q", content)
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	css := []byte("body { color: red; }\n")
+	png := []byte("\x89PNG\x0d\x0a\x1a\x0a\x00\x00\x00\x0dIHDR")
+
+	assert.Equal(t, "text/plain; charset=utf-8", detectContentType(nil, "/site.css", css))
+	assert.Equal(t, "text/css; charset=utf-8", detectContentType(HybridDetection, "/site.css", css))
+	assert.Equal(t, "image/png", detectContentType(HybridDetection, "/wrong.gif", png))
+	assert.Equal(t, "image/gif", detectContentType(
+		[]ContentTypeDetector{DetectExtension, DetectSniff}, "/wrong.gif", png), "precedence is the order given")
+	assert.Equal(t, "text/plain; charset=utf-8", detectContentType(HybridDetection, "/README", css))
+
+	// a script can look just like JSON - the extension wins
+	script := []byte(`["a","b"].forEach(function (x) { console.log(x) })`)
+	assert.Equal(t, "text/javascript; charset=utf-8", detectContentType(HybridDetection, "/app.js", script))
+	assert.Equal(t, "application/json", detectContentType(HybridDetection, "/data", []byte(`["a","b"]`)))
+
+	mine := func(name string, head []byte) string {
+		if strings.HasSuffix(name, ".dandler") {
+			return "application/x-dandler"
+		}
+		return ""
+	}
+	detectors := append([]ContentTypeDetector{mine}, HybridDetection...)
+	assert.Equal(t, "application/x-dandler", detectContentType(detectors, "/a.dandler", css))
+	assert.Equal(t, "text/css; charset=utf-8", detectContentType(detectors, "/a.css", css))
+}
+
+func TestContentType_hybrid(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(ContentType(logger, "./testdata/", WithContentTypeDetection(HybridDetection...)))
+	defer ts.Close()
+
+	for uri, expected := range map[string]string{
+		"/component.css":              "text/css; charset=utf-8",
+		"/grid.js":                    "text/javascript; charset=utf-8",
+		"/page.html":                  "text/html; charset=utf-8",
+		"/accidentally_save_file.gif": "image/png",
+		"/blocked_us.png":             "image/jpeg",
+	} {
+		res, err := http.Get(ts.URL + uri)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode, uri)
+		assert.Equal(t, expected, res.Header.Get("Content-Type"), fmt.Sprintf("content type of %s", uri))
+	}
+}
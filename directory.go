@@ -0,0 +1,156 @@
+package dandler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DirectoryPolicy decides what ContentType does when a directory is
+// requested.
+type DirectoryPolicy int
+
+const (
+	// DirectoriesForbidden refuses to serve directories with a 403. This is
+	// the default.
+	DirectoriesForbidden DirectoryPolicy = iota
+	// DirectoriesIndex serves the index.html within the directory, or a 403
+	// if there is none.
+	DirectoriesIndex
+	// DirectoriesRedirect redirects to the directory with a trailing slash -
+	// so relative links work - then serves it as DirectoriesIndex does.
+	DirectoriesRedirect
+)
+
+// WithDirectories sets what ContentType does when a directory is requested.
+func WithDirectories(policy DirectoryPolicy) Option {
+	return func(o *options) {
+		o.directories = policy
+	}
+}
+
+// errDirectory is returned for a directory that cannot be served.
+var errDirectory = errors.New("is a directory")
+
+// defaultIndexFiles are served for a directory, as allowed by the
+// DirectoryPolicy, unless WithIndexFiles says otherwise.
+var defaultIndexFiles = []string{"index.html"}
+
+// WithIndexFiles sets the files ContentType looks for - in order - when a
+// directory is requested, and WithDirectories allows serving one.
+func WithIndexFiles(names ...string) Option {
+	return func(o *options) {
+		o.indexFiles = names
+	}
+}
+
+// serveDirectory decides what to send in place of the directory at urlPath.
+// It returns true if an index file should be served, or false if the response
+// has already been sent.
+func (c contentTypeHandler) serveDirectory(w http.ResponseWriter, r *http.Request, urlPath string) bool {
+	switch c.directories {
+	case DirectoriesRedirect:
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			target := path.Base(r.URL.Path) + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			// kept relative, so that it still works behind http.StripPrefix
+			w.Header().Set("Location", target)
+			w.WriteHeader(http.StatusMovedPermanently)
+			return false
+		}
+		return true
+	case DirectoriesIndex:
+		return true
+	default:
+		c.fail(w, r, urlPath, errDirectory)
+		return false
+	}
+}
+
+// openIndex opens the first index file found in the directory at urlPath,
+// and returns its path. Without one, there is nothing to show for the
+// directory.
+func (c contentTypeHandler) openIndex(urlPath string) (*os.File, os.FileInfo, string, error) {
+	for _, name := range c.indexFiles {
+		indexPath := path.Join(urlPath, name)
+		f, stat, err := c.open(indexPath)
+		if os.IsNotExist(err) || errors.Is(err, errSymlink) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, indexPath, err
+		}
+		if stat.IsDir() {
+			f.Close()
+			continue
+		}
+		return f, stat, indexPath, nil
+	}
+	return nil, nil, urlPath, errDirectory
+}
+
+// open opens the file at urlPath, as long as the symlink policy and ignore
+// rules allow it.
+func (c contentTypeHandler) open(urlPath string) (*os.File, os.FileInfo, error) {
+	return openAllowed(c.basePath, urlPath, c.symlinks, c.ignore)
+}
+
+// fail sends the error matching why urlPath could not be opened.
+func (c contentTypeHandler) fail(w http.ResponseWriter, r *http.Request, urlPath string, err error) {
+	failOpen(w, r, c.l, c.basePath, urlPath, err)
+}
+
+// openAllowed opens the file at urlPath within basePath, as long as the
+// symlink policy and ignore rules allow it. Ignored paths are reported as not
+// existing.
+func openAllowed(basePath, urlPath string, symlinks SymlinkPolicy, ignore *ignorer) (*os.File, os.FileInfo, error) {
+	f, err := symlinks.open(basePath, urlPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if ignore.ignored(urlPath, stat.IsDir()) {
+		f.Close()
+		return nil, nil, os.ErrNotExist
+	}
+	return f, stat, nil
+}
+
+// openAllowedFile is openAllowed for handlers that only serve files - a
+// directory gives errDirectory.
+func openAllowedFile(basePath, urlPath string, symlinks SymlinkPolicy, ignore *ignorer) (*os.File, os.FileInfo, error) {
+	f, stat, err := openAllowed(basePath, urlPath, symlinks, ignore)
+	if err == nil && stat.IsDir() {
+		f.Close()
+		return nil, nil, errDirectory
+	}
+	return f, stat, err
+}
+
+// failOpen sends the error matching why urlPath could not be opened - anything
+// other than a lack of permission, or a directory, is treated as not found.
+func failOpen(w http.ResponseWriter, r *http.Request, l *log.Logger, basePath, urlPath string, err error) {
+	location := filepath.Join(basePath, filepath.FromSlash(urlPath))
+	switch {
+	case os.IsPermission(err):
+		http.Error(w, fmt.Sprintf("permission denied: %s", r.URL.Path), http.StatusForbidden)
+		l.Printf("403 - permission denied: %s - %s", location, err)
+	case errors.Is(err, errDirectory):
+		http.Error(w, fmt.Sprintf("cannot serve directory: %s", r.URL.Path), http.StatusForbidden)
+		l.Printf("403 - is a directory: %s", location)
+	default:
+		http.Error(w, fmt.Sprintf("not found: %s", r.URL.Path), http.StatusNotFound)
+		l.Printf("404 - could not open file: %s - %s", location, err)
+	}
+}
@@ -0,0 +1,116 @@
+package dandler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentType_smallFiles(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	for name, content := range map[string]string{
+		"empty.txt": "",
+		"short.txt": "ohai",
+		"short.svg": `<svg xmlns="http://www.w3.org/2000/svg"/>`,
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, name), []byte(content), 0644))
+	}
+
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(ContentType(logger, tempdir, WithContentTypeDetection(DetectSignature)))
+	defer ts.Close()
+
+	for uri, expected := range map[string]string{
+		"/empty.txt": "",
+		"/short.txt": "ohai",
+		"/short.svg": `<svg xmlns="http://www.w3.org/2000/svg"/>`,
+	} {
+		res, err := http.Get(ts.URL + uri)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode, uri)
+		assert.Equal(t, expected, string(body), uri)
+		if uri == "/short.svg" {
+			assert.Equal(t, "image/svg+xml", res.Header.Get("Content-Type"), uri)
+		} else {
+			assert.Equal(t, "text/plain; charset=utf-8", res.Header.Get("Content-Type"), uri)
+		}
+	}
+}
+
+func TestContentType_directories(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	require.NoError(t, os.MkdirAll(filepath.Join(tempdir, "site"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(tempdir, "bare", "index.html"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "site", "index.html"),
+		[]byte("<!DOCTYPE html><html><body>ohai</body></html>"), 0644))
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	logger := log.New(ioutil.Discard, "", 0)
+
+	var testData = []struct {
+		policy   DirectoryPolicy
+		uri      string
+		code     int
+		location string
+	}{
+		{policy: DirectoriesForbidden, uri: "/site/", code: http.StatusForbidden},
+		{policy: DirectoriesForbidden, uri: "/site", code: http.StatusForbidden},
+		{policy: DirectoriesIndex, uri: "/site", code: http.StatusOK},
+		{policy: DirectoriesIndex, uri: "/site/", code: http.StatusOK},
+		{policy: DirectoriesIndex, uri: "/bare/", code: http.StatusForbidden},
+		{policy: DirectoriesIndex, uri: "/", code: http.StatusForbidden},
+		{policy: DirectoriesRedirect, uri: "/site", code: http.StatusMovedPermanently, location: "site/"},
+		{policy: DirectoriesRedirect, uri: "/site?a=b", code: http.StatusMovedPermanently, location: "site/?a=b"},
+		{policy: DirectoriesRedirect, uri: "/site/", code: http.StatusOK},
+		{policy: DirectoriesRedirect, uri: "/missing/", code: http.StatusNotFound},
+	}
+	for _, test := range testData {
+		ts := httptest.NewServer(ContentType(logger, tempdir, WithDirectories(test.policy)))
+		res, err := noRedirect.Get(ts.URL + test.uri)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		ts.Close()
+		require.NoError(t, err)
+
+		assert.Equal(t, test.code, res.StatusCode, "%d %s", test.policy, test.uri)
+		assert.Equal(t, test.location, res.Header.Get("Location"), "%d %s", test.policy, test.uri)
+		if test.code == http.StatusOK {
+			assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+			assert.True(t, bytes.Contains(body, []byte("ohai")))
+		}
+	}
+}
+
+func TestContentType_fail(t *testing.T) {
+	var buf bytes.Buffer
+	c := contentTypeHandler{basePath: "/srv", l: log.New(&buf, "", 0)}
+	for err, code := range map[error]int{
+		os.ErrNotExist:   http.StatusNotFound,
+		os.ErrPermission: http.StatusForbidden,
+		errDirectory:     http.StatusForbidden,
+		errSymlink:       http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		c.fail(w, httptest.NewRequest("GET", "/a.txt", nil), "/a.txt", err)
+		assert.Equal(t, code, w.Code, err.Error())
+	}
+	assert.Contains(t, buf.String(), "403 - permission denied: /srv/a.txt")
+}
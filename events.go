@@ -0,0 +1,132 @@
+package dandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WithEvents tells Index where an IndexEvents handler is mounted, so that
+// IndexData.Events links to the event stream for each directory. The built-in
+// templates use it to refresh the page when the directory changes.
+func WithEvents(prefix string) Option {
+	return func(o *options) {
+		o.events = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// IndexEvent is sent by IndexEvents for each change within a directory. Op is
+// one of add, remove or rename - a rename is sent for both the old and the
+// new name.
+type IndexEvent struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	Name string `json:"name"`
+}
+
+// IndexEvents watches a directory - and sub directories - and streams changes
+// to the requested directory as Server-Sent Events. Each event is named after
+// its Op, with an IndexEvent as JSON for data. Changes to ignored paths - see
+// WithIgnore - are not reported. Every stream is ended when done is closed.
+func IndexEvents(logger *log.Logger, basepath string, done <-chan struct{}, opts ...Option) http.Handler {
+	o := buildOptions(opts)
+	w, err := watchChanges(basepath, done)
+	if err != nil {
+		logger.Printf("failed to watch directory [%s] - %v", basepath, err)
+		return ResponseCode(500, "failed to initialize IndexEvents - %v", err)
+	}
+	return eventsHandler{basePath: basepath, watch: w, done: done, l: logger, keepalive: 30 * time.Second,
+		ignore: newListIgnorer(http.Dir(basepath), o)}
+}
+
+type eventsHandler struct {
+	basePath  string
+	watch     *watcher
+	done      <-chan struct{}
+	l         *log.Logger
+	keepalive time.Duration
+	ignore    *ignorer
+}
+
+func (h eventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	dirPath := path.Clean("/" + r.URL.Path)
+	stat, err := os.Stat(filepath.Join(h.basePath, filepath.FromSlash(dirPath)))
+	if err != nil || !stat.IsDir() || h.ignore.ignored(dirPath, true) {
+		http.Error(w, fmt.Sprintf("not found: %s", r.URL.Path), http.StatusNotFound)
+		h.l.Printf("404 - not a directory: %s - %v", filepath.Join(h.basePath, r.URL.Path), err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		h.l.Printf("500 - response cannot be streamed: %s", r.URL.Path)
+		return
+	}
+
+	// the watcher only hands changes over - checking them is left to this
+	// request, so that no client holds up the watcher
+	changes := make(chan change, 32)
+	unsubscribe := h.watch.subscribe(func(c change) {
+		if path.Dir(c.Path) != dirPath {
+			return
+		}
+		if c.Op != changeAdd && c.Op != changeRemove && c.Op != changeRename {
+			return
+		}
+		select {
+		case changes <- c:
+		default:
+			// a slow client misses events, rather than holding up the watcher
+		}
+	})
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	keepalive := time.NewTicker(h.keepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.done:
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case c := <-changes:
+			if h.ignored(c) {
+				continue
+			}
+			event := IndexEvent{Op: c.Op, Path: c.Path, Name: path.Base(c.Path)}
+			data, err := json.Marshal(event)
+			if err != nil {
+				h.l.Printf("500 - could not encode event: %s", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Op, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// ignored reports if a change is to an ignored path. Anything removed is no
+// longer there to check, so is taken as a file.
+func (h eventsHandler) ignored(c change) bool {
+	isDir := false
+	if info, err := os.Stat(filepath.Join(h.basePath, filepath.FromSlash(c.Path))); err == nil {
+		isDir = info.IsDir()
+	}
+	return h.ignore.ignored(c.Path, isDir)
+}
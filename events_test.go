@@ -0,0 +1,111 @@
+package dandler
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nextEvent reads from an event stream until a whole event has been sent.
+func nextEvent(t *testing.T, lines *bufio.Scanner) (string, IndexEvent) {
+	var name string
+	var event IndexEvent
+	for lines.Scan() {
+		line := lines.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+		case line == "" && name != "":
+			return name, event
+		}
+	}
+	return "", event
+}
+
+func TestIndexEvents(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	require.NoError(t, os.MkdirAll(filepath.Join(tempdir, "sub"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(tempdir, "other"), 0755))
+
+	done := make(chan struct{})
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(IndexEvents(logger, tempdir, done))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/missing/")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res, err = http.Get(ts.URL + "/sub/")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	lines := bufio.NewScanner(res.Body)
+
+	// changes elsewhere, and to hidden files, are not reported
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "other", "elsewhere.txt"), nil, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "sub", ".hidden"), nil, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "sub", "new.txt"), nil, 0644))
+
+	name, event := nextEvent(t, lines)
+	assert.Equal(t, "add", name)
+	assert.Equal(t, IndexEvent{Op: "add", Path: "/sub/new.txt", Name: "new.txt"}, event)
+
+	require.NoError(t, os.Remove(filepath.Join(tempdir, "sub", "new.txt")))
+	name, event = nextEvent(t, lines)
+	assert.Equal(t, "remove", name)
+	assert.Equal(t, "/sub/new.txt", event.Path)
+
+	// closing done ends the stream
+	close(done)
+	finished := make(chan struct{})
+	go func() {
+		for lines.Scan() {
+		}
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Error("stream did not end when done was closed")
+	}
+}
+
+func TestIndex_withEvents(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Index(logger, "testdata/sample_images", done, nil, WithEvents("/events/")))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/edat/")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `new EventSource("/events/edat/")`)
+
+	res, err = http.Get(ts.URL + "/edat/?format=json")
+	require.NoError(t, err)
+	var data IndexData
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&data))
+	res.Body.Close()
+	assert.Equal(t, "/events/edat/", data.Events)
+}
@@ -0,0 +1,70 @@
+package dandler
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// WithFallback makes ContentType serve file - such as the index.html of a
+// single page app - in place of any path that does not exist, so that the app
+// can route it itself. Paths that look like assets - ending in one of the
+// extensions set with WithFallbackAssets - still get a 404, as do paths at or
+// below any of the excluded prefixes, such as "/api". Other dots are fine, so
+// routes such as /users/john.doe reach the app.
+func WithFallback(file string, exclude ...string) Option {
+	return func(o *options) {
+		o.fallback = path.Clean("/" + file)
+		o.fallbackExclude = exclude
+	}
+}
+
+// DefaultAssetExtensions are the extensions WithFallback treats as assets,
+// unless WithFallbackAssets says otherwise.
+var DefaultAssetExtensions = []string{
+	".html", ".htm", ".css", ".js", ".mjs", ".map", ".json", ".webmanifest", ".wasm",
+	".png", ".jpg", ".jpeg", ".gif", ".webp", ".avif", ".svg", ".ico",
+	".woff", ".woff2", ".ttf", ".otf", ".eot",
+	".txt", ".xml", ".pdf", ".zip", ".mp3", ".mp4", ".webm",
+}
+
+// WithFallbackAssets sets which extensions mark a path as an asset, so that it
+// gets a 404 when missing rather than the WithFallback file. To add to the
+// extensions known, pass DefaultAssetExtensions along with your own.
+func WithFallbackAssets(extensions ...string) Option {
+	return func(o *options) {
+		o.fallbackAssets = extensions
+	}
+}
+
+// isAsset reports if urlPath ends in one of the asset extensions.
+func isAsset(urlPath string, extensions []string) bool {
+	ext := path.Ext(urlPath)
+	if ext == "" {
+		return false
+	}
+	for _, each := range extensions {
+		if strings.EqualFold(ext, each) {
+			return true
+		}
+	}
+	return false
+}
+
+// fallbackFor returns the file to serve in place of the missing urlPath, if
+// there is one.
+func (c contentTypeHandler) fallbackFor(r *http.Request, urlPath string) (string, bool) {
+	if c.fallback == "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return "", false
+	}
+	if isAsset(urlPath, c.fallbackAssets) {
+		return "", false
+	}
+	for _, prefix := range c.fallbackExclude {
+		prefix = strings.TrimSuffix(path.Clean("/"+prefix), "/")
+		if urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/") {
+			return "", false
+		}
+	}
+	return c.fallback, true
+}
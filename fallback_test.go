@@ -0,0 +1,110 @@
+package dandler
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentType_fallback(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	for name, content := range map[string]string{
+		"index.html":     "<!DOCTYPE html><html><body>app</body></html>",
+		"assets/app.css": "body { color: red; }",
+		"docs/home.htm":  "<!DOCTYPE html><html><body>docs</body></html>",
+	} {
+		location := filepath.Join(tempdir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(location), 0755))
+		require.NoError(t, ioutil.WriteFile(location, []byte(content), 0644))
+	}
+
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(ContentType(logger, tempdir, WithFallback("index.html", "/api", "/static/"),
+		WithDirectories(DirectoriesIndex), WithIndexFiles("index.htm", "home.htm", "index.html")))
+	defer ts.Close()
+
+	var testData = []struct {
+		method string
+		uri    string
+		code   int
+		body   string
+	}{
+		{method: "GET", uri: "/", code: http.StatusOK, body: "app"},
+		{method: "GET", uri: "/users/42", code: http.StatusOK, body: "app"},
+		{method: "HEAD", uri: "/users/42", code: http.StatusOK},
+		{method: "GET", uri: "/docs/", code: http.StatusOK, body: "docs"},
+		{method: "GET", uri: "/assets/app.css", code: http.StatusOK, body: "body { color: red; }"},
+		{method: "GET", uri: "/assets/missing.js", code: http.StatusNotFound},
+		{method: "GET", uri: "/api", code: http.StatusNotFound},
+		{method: "GET", uri: "/api/users", code: http.StatusNotFound},
+		{method: "GET", uri: "/apiary", code: http.StatusOK, body: "app"},
+		{method: "GET", uri: "/static/thing", code: http.StatusNotFound},
+		{method: "POST", uri: "/users/42", code: http.StatusNotFound},
+		{method: "GET", uri: "/users/john.doe", code: http.StatusOK, body: "app"},
+		{method: "GET", uri: "/v1.2/docs", code: http.StatusOK, body: "app"},
+		{method: "GET", uri: "/v1.2", code: http.StatusOK, body: "app"},
+		{method: "GET", uri: "/assets/missing.PNG", code: http.StatusNotFound},
+		{method: "GET", uri: "/static/john.doe", code: http.StatusNotFound},
+	}
+	for _, test := range testData {
+		req, err := http.NewRequest(test.method, ts.URL+test.uri, nil)
+		require.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+
+		assert.Equal(t, test.code, res.StatusCode, "%s %s", test.method, test.uri)
+		if test.body != "" {
+			assert.Contains(t, string(body), test.body, "%s %s", test.method, test.uri)
+		}
+	}
+}
+
+func TestContentType_indexFiles(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "index.html"), []byte("html"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "default.txt"), []byte("txt"), 0644))
+
+	logger := log.New(ioutil.Discard, "", 0)
+	for expected, opts := range map[string][]Option{
+		"html": {WithDirectories(DirectoriesIndex)},
+		"txt":  {WithDirectories(DirectoriesIndex), WithIndexFiles("default.txt", "index.html")},
+		"":     {WithDirectories(DirectoriesIndex), WithIndexFiles("missing.html")},
+	} {
+		ts := httptest.NewServer(ContentType(logger, tempdir, opts...))
+		res, err := http.Get(ts.URL + "/")
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		ts.Close()
+		require.NoError(t, err)
+		if expected == "" {
+			assert.Equal(t, http.StatusForbidden, res.StatusCode)
+			continue
+		}
+		assert.Equal(t, http.StatusOK, res.StatusCode, expected)
+		assert.Equal(t, expected, string(body))
+	}
+}
+
+func TestIsAsset(t *testing.T) {
+	assert.True(t, isAsset("/assets/app.css", DefaultAssetExtensions))
+	assert.True(t, isAsset("/logo.SVG", DefaultAssetExtensions))
+	assert.False(t, isAsset("/users/john.doe", DefaultAssetExtensions))
+	assert.False(t, isAsset("/v1.2/docs", DefaultAssetExtensions))
+	assert.True(t, isAsset("/users/john.doe", append(DefaultAssetExtensions[:len(DefaultAssetExtensions):len(DefaultAssetExtensions)], ".doe")))
+	assert.False(t, isAsset("/app.css", nil))
+}
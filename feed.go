@@ -0,0 +1,395 @@
+package dandler
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Feed sends the most recently modified files in the requested directory as
+// an RSS 2.0 or Atom feed, so that new files can be subscribed to. Files are
+// found as Index finds them - with the same ignore rules, symlink policy and
+// sidecar metadata - and images, audio and video are attached as enclosures.
+// If WithSrcset is given, each image also links to a thumbnail. Links are made
+// absolute with WithBaseURL, or the Host the request was sent to.
+//
+// The feed is shaped with query parameters:
+//
+//	format     rss (the default) or atom - otherwise picked from Accept
+//	recursive  if true, files in every directory below are included - only
+//	           with WithRecursiveFeeds
+//	limit      how many files to include - defaults to count, and can be no more
+func Feed(logger *log.Logger, basepath string, count int, opts ...Option) http.Handler {
+	o := buildOptions(opts)
+	return feedHandler{
+		basePath: basepath,
+		l:        logger,
+		count:    count,
+		ignore:   newListIgnorer(http.Dir(basepath), o),
+		symlinks: o.symlinks,
+		meta:     newMetaCache(logger),
+		srcset:   o.srcset,
+		baseURL:  o.baseURL,
+
+		recursive: o.feedRecursive,
+		maxFiles:  o.feedMaxFiles,
+	}
+}
+
+// WithRecursiveFeeds lets Feed include the files in every directory below the
+// one requested, when asked for with ?recursive=1. At most maxFiles files are
+// looked through to pick the newest from - the walk stops there, so that a
+// large tree cannot hold up the server. A maxFiles of 0 or less removes the
+// limit.
+func WithRecursiveFeeds(maxFiles int) Option {
+	return func(o *options) {
+		o.feedRecursive = true
+		o.feedMaxFiles = maxFiles
+	}
+}
+
+type feedHandler struct {
+	basePath string
+	l        *log.Logger
+	count    int
+	ignore   *ignorer
+	symlinks SymlinkPolicy
+	meta     *metaCache
+	srcset   Srcset
+	baseURL  string
+
+	recursive bool
+	maxFiles  int
+}
+
+// feedEntry is a file that may be included in a feed, with what is needed to
+// describe it.
+type feedEntry struct {
+	entry    IndexEntry
+	location string
+	names    map[string]os.FileInfo
+}
+
+func (h feedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	limit := h.count
+	if raw := values.Get("limit"); raw != "" {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			http.Error(w, fmt.Sprintf("bad limit: %s", raw), http.StatusBadRequest)
+			h.l.Printf("400 - bad limit requested: %s", raw)
+			return
+		}
+		if limit > h.count {
+			limit = h.count
+		}
+	}
+	recursive, _ := strconv.ParseBool(values.Get("recursive"))
+	recursive = recursive && h.recursive
+
+	dirPath := path.Clean("/" + r.URL.Path)
+	location, stat, err := h.symlinks.resolve(h.basePath, dirPath)
+	if err == nil && h.ignore.ignored(dirPath, stat.IsDir()) {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("not found: %s", r.URL.Path), http.StatusNotFound)
+		h.l.Printf("404 - could not find directory: %s - %s", filepath.Join(h.basePath, dirPath), err)
+		return
+	}
+	if !stat.IsDir() {
+		http.Error(w, fmt.Sprintf("cannot read target: %s", r.URL.Path), http.StatusForbidden)
+		h.l.Printf("403 - not a directory: %s", location)
+		return
+	}
+
+	files := h.collect(location, dirPath, recursive, []os.FileInfo{stat})
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].entry.ModTime.After(files[j].entry.ModTime)
+	})
+	if len(files) > limit {
+		files = files[:limit]
+	}
+	entries := make([]IndexEntry, len(files))
+	for i := range files {
+		described := []IndexEntry{files[i].entry}
+		h.meta.describe(files[i].location, files[i].names, described)
+		entries[i] = described[0]
+		entries[i].probe(filepath.Join(files[i].location, entries[i].Name))
+	}
+
+	base := baseURL(h.baseURL, r)
+	feed := feedData{
+		Title:   "Recent files in " + dirPath,
+		Link:    absoluteURL(base, dirHref(dirPath)),
+		Self:    absoluteURL(base, r.URL.RequestURI()),
+		Author:  base.Host,
+		Entries: entries,
+	}
+
+	format := strings.ToLower(values.Get("format"))
+	if format == "" && acceptQuality(r.Header.Get("Accept"), "application/atom+xml") >
+		acceptQuality(r.Header.Get("Accept"), "application/rss+xml") {
+		format = "atom"
+	}
+	w.Header().Add("Vary", "Accept")
+
+	var doc interface{}
+	switch format {
+	case "atom":
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		doc = feed.atom(base, h.srcset)
+	case "", "rss":
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		doc = feed.rss(base, h.srcset)
+	default:
+		http.Error(w, fmt.Sprintf("unknown feed format: %s", format), http.StatusBadRequest)
+		h.l.Printf("400 - unknown feed format: %s", format)
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		w.Header().Del("Content-Type")
+		http.Error(w, fmt.Sprintf("error building response: %s", r.URL.Path), http.StatusInternalServerError)
+		h.l.Printf("500 - error responding: %s", err)
+		return
+	}
+	buf.WriteTo(w)
+}
+
+// collect finds every file in the directory at location - and below it, if
+// recursive - never following symlinks back into a directory already being
+// looked through. Below the directory, the walk stops once maxFiles files are
+// found.
+func (h feedHandler) collect(location, dirPath string, recursive bool, ancestors []os.FileInfo) []feedEntry {
+	var files []feedEntry
+	var walk func(location, dirPath string, ancestors []os.FileInfo)
+	walk = func(location, dirPath string, ancestors []os.FileInfo) {
+		f, err := os.Open(location)
+		if err != nil {
+			return
+		}
+		contents, err := f.Readdir(0)
+		f.Close()
+		if err != nil {
+			return
+		}
+
+		entries, names := listEntries(h.basePath, dirPath, contents, h.ignore, h.symlinks)
+		var dirs []IndexEntry
+		for _, entry := range entries {
+			if entry.IsDir {
+				dirs = append(dirs, entry)
+				continue
+			}
+			files = append(files, feedEntry{entry: entry, location: location, names: names})
+		}
+		if !recursive {
+			return
+		}
+		for _, entry := range dirs {
+			if h.maxFiles > 0 && len(files) >= h.maxFiles {
+				return
+			}
+			sub := filepath.Join(location, entry.Name)
+			stat, err := os.Stat(sub)
+			if err != nil || isLoop(ancestors, stat) {
+				continue
+			}
+			walk(sub, entry.Path, append(ancestors[:len(ancestors):len(ancestors)], stat))
+		}
+	}
+	walk(location, dirPath, ancestors)
+	return files
+}
+
+// feedData is what goes in a feed, before it is put in either format.
+type feedData struct {
+	Title   string
+	Link    string
+	Self    string
+	Author  string
+	Entries []IndexEntry
+}
+
+// updated is when the newest file in the feed was changed.
+func (f feedData) updated() time.Time {
+	var newest time.Time
+	for _, entry := range f.Entries {
+		if entry.ModTime.After(newest) {
+			newest = entry.ModTime
+		}
+	}
+	return newest
+}
+
+// isMedia reports if an entry should be attached to the feed as an enclosure.
+func isMedia(entry IndexEntry) bool {
+	for _, prefix := range []string{"image/", "audio/", "video/"} {
+		if strings.HasPrefix(entry.ContentType, prefix) {
+			return true
+		}
+	}
+	return entry.ContentType == "application/ogg"
+}
+
+// feedThumbnail links to a thumbnail of an image, if there are thumbnails.
+func feedThumbnail(base *url.URL, srcset Srcset, entry IndexEntry) *mediaThumbnail {
+	if srcset.Pattern == "" || !entry.IsImage() {
+		return nil
+	}
+	width := 0
+	if len(srcset.Widths) > 0 {
+		width = srcset.Widths[0]
+	}
+	return &mediaThumbnail{URL: absoluteURL(base, srcset.URL(entry.Path, width))}
+}
+
+// mediaNS is the Media RSS namespace, used for thumbnails in both formats.
+const mediaNS = "http://search.yahoo.com/mrss/"
+
+type mediaThumbnail struct {
+	URL string `xml:"url,attr"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Media   string     `xml:"xmlns:media,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string          `xml:"title"`
+	Link        string          `xml:"link"`
+	Description string          `xml:"description,omitempty"`
+	GUID        string          `xml:"guid"`
+	PubDate     string          `xml:"pubDate"`
+	Enclosure   *rssEnclosure   `xml:"enclosure"`
+	Thumbnail   *mediaThumbnail `xml:"media:thumbnail"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+func (f feedData) rss(base *url.URL, srcset Srcset) rssFeed {
+	feed := rssFeed{Version: "2.0", Media: mediaNS, Channel: rssChannel{
+		Title:       f.Title,
+		Link:        f.Link,
+		Description: f.Title,
+		Items:       []rssItem{},
+	}}
+	if updated := f.updated(); !updated.IsZero() {
+		feed.Channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+	for _, entry := range f.Entries {
+		link := absoluteURL(base, entry.Href)
+		item := rssItem{
+			Title:       entry.Name,
+			Link:        link,
+			Description: entry.Description,
+			GUID:        link,
+			PubDate:     entry.ModTime.UTC().Format(time.RFC1123Z),
+			Thumbnail:   feedThumbnail(base, srcset, entry),
+		}
+		if entry.Title != "" {
+			item.Title = entry.Title
+		}
+		if isMedia(entry) {
+			item.Enclosure = &rssEnclosure{URL: link, Length: entry.Size, Type: entry.ContentType}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+	return feed
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Media   string      `xml:"xmlns:media,attr"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string          `xml:"title"`
+	ID        string          `xml:"id"`
+	Updated   string          `xml:"updated"`
+	Links     []atomLink      `xml:"link"`
+	Summary   string          `xml:"summary,omitempty"`
+	Thumbnail *mediaThumbnail `xml:"media:thumbnail"`
+}
+
+func (f feedData) atom(base *url.URL, srcset Srcset) atomFeed {
+	feed := atomFeed{
+		Media:   mediaNS,
+		Title:   f.Title,
+		ID:      f.Link,
+		Updated: f.updated().UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: f.Author},
+		Links: []atomLink{
+			{Rel: "alternate", Href: f.Link, Type: "text/html"},
+			{Rel: "self", Href: f.Self, Type: "application/atom+xml"},
+		},
+	}
+	for _, entry := range f.Entries {
+		link := absoluteURL(base, entry.Href)
+		item := atomEntry{
+			Title:     entry.Name,
+			ID:        link,
+			Updated:   entry.ModTime.UTC().Format(time.RFC3339),
+			Links:     []atomLink{{Rel: "alternate", Href: link}},
+			Summary:   entry.Description,
+			Thumbnail: feedThumbnail(base, srcset, entry),
+		}
+		if entry.Title != "" {
+			item.Title = entry.Title
+		}
+		if isMedia(entry) {
+			item.Links = append(item.Links, atomLink{Rel: "enclosure", Href: link,
+				Type: entry.ContentType, Length: entry.Size})
+		}
+		feed.Entries = append(feed.Entries, item)
+	}
+	return feed
+}
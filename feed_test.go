@@ -0,0 +1,159 @@
+package dandler
+
+import (
+	"encoding/xml"
+	"image"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func feedFixture(t *testing.T) string {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(tempdir, "photos", "older"), 0755))
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range []string{"photos/older/first.png", "photos/notes.txt", ".hidden.png", "photos/newest.png"} {
+		location := filepath.Join(tempdir, filepath.FromSlash(name))
+		if filepath.Ext(name) == ".png" {
+			writeTestPNG(t, location, image.NewGray(image.Rect(0, 0, 2, 2)))
+		} else {
+			require.NoError(t, ioutil.WriteFile(location, []byte("ohai"), 0644))
+		}
+		mtime := start.Add(time.Duration(i) * time.Hour)
+		require.NoError(t, os.Chtimes(location, mtime, mtime))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "photos", "newest.png.yaml"),
+		[]byte("title: The newest one\ndescription: Freshly dropped\n"), 0644))
+	return tempdir
+}
+
+func TestFeed_rss(t *testing.T) {
+	tempdir := feedFixture(t)
+	defer os.RemoveAll(tempdir)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Feed(logger, tempdir, 10, WithRecursiveFeeds(0),
+		WithSrcset(Srcset{Widths: []int{100}, Pattern: "/t/{width}{path}"})))
+	defer ts.Close()
+
+	get := func(uri string) (*http.Response, rssFeed) {
+		res, err := http.Get(ts.URL + uri)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		var feed rssFeed
+		if res.StatusCode == http.StatusOK {
+			require.NoError(t, xml.NewDecoder(res.Body).Decode(&feed))
+		}
+		return res, feed
+	}
+
+	res, feed := get("/photos/")
+	assert.Equal(t, "application/rss+xml; charset=utf-8", res.Header.Get("Content-Type"))
+	require.Len(t, feed.Channel.Items, 2)
+	newest := feed.Channel.Items[0]
+	assert.Equal(t, "The newest one", newest.Title)
+	assert.Equal(t, "Freshly dropped", newest.Description)
+	assert.Equal(t, ts.URL+"/photos/newest.png", newest.Link)
+	assert.Equal(t, "Wed, 01 Jan 2020 03:00:00 +0000", newest.PubDate)
+	require.NotNil(t, newest.Enclosure)
+	assert.Equal(t, "image/png", newest.Enclosure.Type)
+	assert.Equal(t, ts.URL+"/photos/newest.png", newest.Enclosure.URL)
+	assert.Equal(t, "notes.txt", feed.Channel.Items[1].Title)
+	assert.Nil(t, feed.Channel.Items[1].Enclosure, "only media is attached")
+
+	_, feed = get("/?recursive=1&limit=2")
+	require.Len(t, feed.Channel.Items, 2)
+	assert.Equal(t, ts.URL+"/photos/newest.png", feed.Channel.Items[0].Link)
+	assert.Equal(t, ts.URL+"/photos/notes.txt", feed.Channel.Items[1].Link)
+
+	_, feed = get("/?recursive=1")
+	require.Len(t, feed.Channel.Items, 3, "hidden files are left out")
+	assert.Equal(t, ts.URL+"/photos/older/first.png", feed.Channel.Items[2].Link)
+
+	res, err := http.Get(ts.URL + "/photos/")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `<media:thumbnail url="`+ts.URL+`/t/100/photos/newest.png">`)
+
+	for uri, code := range map[string]int{
+		"/missing/":           http.StatusNotFound,
+		"/photos/notes.txt":   http.StatusForbidden,
+		"/photos/?limit=0":    http.StatusBadRequest,
+		"/photos/?format=xml": http.StatusBadRequest,
+	} {
+		res, _ := get(uri)
+		assert.Equal(t, code, res.StatusCode, uri)
+	}
+}
+
+func TestFeed_limits(t *testing.T) {
+	tempdir := feedFixture(t)
+	defer os.RemoveAll(tempdir)
+	logger := log.New(ioutil.Discard, "", 0)
+
+	get := func(h http.Handler, uri string) []rssItem {
+		ts := httptest.NewServer(h)
+		defer ts.Close()
+		res, err := http.Get(ts.URL + uri)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode, uri)
+		var feed rssFeed
+		require.NoError(t, xml.NewDecoder(res.Body).Decode(&feed))
+		return feed.Channel.Items
+	}
+
+	// no more than count can be asked for
+	assert.Len(t, get(Feed(logger, tempdir, 1), "/photos/?limit=1000"), 1)
+
+	// without WithRecursiveFeeds, only the directory itself is looked through
+	assert.Empty(t, get(Feed(logger, tempdir, 10), "/?recursive=1"))
+
+	// the walk stops once enough files are found - the requested directory is
+	// always looked through in full
+	assert.Len(t, get(Feed(logger, tempdir, 10, WithRecursiveFeeds(2)), "/?recursive=1"), 2)
+	assert.Len(t, get(Feed(logger, tempdir, 10, WithRecursiveFeeds(2)), "/photos/?recursive=1"), 2)
+	assert.Len(t, get(Feed(logger, tempdir, 10, WithRecursiveFeeds(3)), "/photos/?recursive=1"), 3)
+}
+
+func TestFeed_atom(t *testing.T) {
+	tempdir := feedFixture(t)
+	defer os.RemoveAll(tempdir)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Feed(logger, tempdir, 1))
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL+"/photos/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/atom+xml")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "application/atom+xml; charset=utf-8", res.Header.Get("Content-Type"))
+
+	var feed atomFeed
+	require.NoError(t, xml.NewDecoder(res.Body).Decode(&feed))
+	assert.Equal(t, "2020-01-01T03:00:00Z", feed.Updated)
+	assert.Equal(t, ts.URL+"/photos/", feed.ID)
+	require.Len(t, feed.Entries, 1)
+	assert.Equal(t, "The newest one", feed.Entries[0].Title)
+	assert.Equal(t, "Freshly dropped", feed.Entries[0].Summary)
+	require.Len(t, feed.Entries[0].Links, 2)
+	enclosure := feed.Entries[0].Links[1]
+	assert.Equal(t, "enclosure", enclosure.Rel)
+	assert.Equal(t, ts.URL+"/photos/newest.png", enclosure.Href)
+	assert.Equal(t, "image/png", enclosure.Type)
+	assert.NotZero(t, enclosure.Length)
+	assert.Nil(t, feed.Entries[0].Thumbnail, "no thumbnails without a srcset")
+}
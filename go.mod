@@ -3,14 +3,16 @@ module github.com/jakdept/dandler
 go 1.16
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/jakdept/dir v0.0.0-20200720120618-28a28464f622
 	github.com/jakdept/drings v0.0.0-20170609025451-f0a517d8686f
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/oliamb/cutter v0.2.2
-	github.com/rjeczalik/notify v0.9.2 // indirect
+	github.com/rjeczalik/notify v0.9.2
 	github.com/sebdah/goldie v1.0.0
 	github.com/stretchr/testify v1.7.0
 	github.com/traherom/memstream v0.0.0-20210211152058-869756e84126
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -36,7 +36,10 @@ func (p splitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // the other locations. It then watches a directory - and sub directories - and
 // any request that would match a directory relative to that path is routed to
 // the handler for directories. All other requests are routed to the other handler.
-func DirSplit(logger *log.Logger, basepath string, done <-chan struct{}, folder, other http.Handler) http.Handler {
+// Symlinks to directories are routed to the handler for directories when
+// WithSymlinks allows following them.
+func DirSplit(logger *log.Logger, basepath string, done <-chan struct{}, folder, other http.Handler, opts ...Option) http.Handler {
+	o := buildOptions(opts)
 	tracker, err := dir.Watch(basepath)
 	if err != nil {
 		log.Fatalf("failed to watch directory [%s] - %v", basepath, err)
@@ -46,17 +49,32 @@ func DirSplit(logger *log.Logger, basepath string, done <-chan struct{}, folder,
 		tracker.Close()
 	}()
 
-	return dirSplitHandler{dir: tracker, folder: folder, other: other}
+	return dirSplitHandler{dir: tracker, folder: folder, other: other, basePath: basepath, symlinks: o.symlinks}
 }
 
 type dirSplitHandler struct {
-	dir    *dir.Tracker
-	folder http.Handler
-	other  http.Handler
+	dir      *dir.Tracker
+	folder   http.Handler
+	other    http.Handler
+	basePath string
+	symlinks SymlinkPolicy
+}
+
+// isDir reports if p is a directory - the tracker does not follow symlinks, so
+// they are checked against the policy.
+func (h dirSplitHandler) isDir(p string) bool {
+	if h.dir.In(p) {
+		return true
+	}
+	if h.symlinks == SymlinksIgnore {
+		return false
+	}
+	_, info, err := h.symlinks.resolve(h.basePath, p)
+	return err == nil && info.IsDir()
 }
 
 func (h dirSplitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if h.dir.In(path.Clean(r.URL.Path)) {
+	if h.isDir(path.Clean(r.URL.Path)) {
 		h.folder.ServeHTTP(w, r)
 	} else {
 		h.other.ServeHTTP(w, r)
@@ -64,53 +82,104 @@ func (h dirSplitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 // ContentType serves a given file back to the requester, and determines content type by algorithm only.
-// It does not use the file's extension to determine the content type.
-func ContentType(logger *log.Logger, basePath string) http.Handler {
-	return contentTypeHandler{basePath: basePath, l: logger}
+// It does not use the file's extension to determine the content type, unless
+// told to with WithContentTypeDetection - see HybridDetection.
+// Paths ignored with WithIgnore or WithIgnoreFile are not served, and symlinks
+// are only followed as WithSymlinks allows.
+//
+// If there is a .br, .zst or .gz file beside the one requested, and the
+// client accepts that encoding, it is sent in its place - with the
+// Content-Type of the original, and Content-Encoding set.
+//
+// Directories are refused, unless WithDirectories says otherwise - see also
+// WithIndexFiles. Files that cannot be read for lack of permission get a 403,
+// rather than a 404. Single page apps can route paths that do not exist
+// themselves, with WithFallback.
+func ContentType(logger *log.Logger, basePath string, opts ...Option) http.Handler {
+	o := buildOptions(opts)
+	return contentTypeHandler{basePath: basePath, l: logger, ignore: newIgnorer(http.Dir(basePath), o),
+		symlinks: o.symlinks, detectors: o.detectors, directories: o.directories,
+		indexFiles: o.indexFiles, fallback: o.fallback, fallbackExclude: o.fallbackExclude,
+		fallbackAssets: o.fallbackAssets}
 }
 
 type contentTypeHandler struct {
-	basePath string
-	l        *log.Logger
+	basePath    string
+	l           *log.Logger
+	ignore      *ignorer
+	symlinks    SymlinkPolicy
+	detectors   []ContentTypeDetector
+	directories DirectoryPolicy
+	indexFiles  []string
+
+	fallback        string
+	fallbackExclude []string
+	fallbackAssets  []string
 }
 
 // contentTypeHandler.ServeHTTP satasifies the Handler interface.
 func (c contentTypeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	f, err := os.Open(filepath.Join(c.basePath, r.URL.Path))
+	urlPath := path.Clean("/" + r.URL.Path)
+	f, stat, err := c.open(urlPath)
+	if err != nil && !os.IsPermission(err) {
+		if fallback, ok := c.fallbackFor(r, urlPath); ok {
+			urlPath = fallback
+			f, stat, err = c.open(urlPath)
+		}
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("not found: %s", r.URL.Path), http.StatusNotFound)
-		c.l.Printf("404 - could not open file: %s - %s", filepath.Join(c.basePath, r.URL.Path), err)
+		c.fail(w, r, urlPath, err)
 		return
 	}
-	defer f.Close()
 
-	stat, err := f.Stat()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("cannot read file: %s", r.URL.Path), http.StatusInternalServerError)
-		c.l.Printf("500 - could not stat file: %s - %s", filepath.Join(c.basePath, r.URL.Path), err)
-		return
+	if stat.IsDir() {
+		f.Close()
+		if !c.serveDirectory(w, r, urlPath) {
+			return
+		}
+		f, stat, urlPath, err = c.openIndex(urlPath)
+		if err != nil {
+			c.fail(w, r, urlPath, err)
+			return
+		}
 	}
+	defer f.Close()
 
+	// short files are sniffed as they are, rather than padded with zeros
 	chunk := make([]byte, 512)
-
-	_, err = f.Read(chunk)
-	if err != nil {
+	n, err := io.ReadFull(f, chunk)
+	if os.IsPermission(err) {
+		c.fail(w, r, urlPath, err)
+		return
+	}
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 		http.Error(w, fmt.Sprintf("cannot read file: %s", r.URL.Path), http.StatusInternalServerError)
-		c.l.Printf("500 - could not read from file: %s - %s", filepath.Join(c.basePath, r.URL.Path), err)
+		c.l.Printf("500 - could not read from file: %s - %s", filepath.Join(c.basePath, urlPath), err)
 		return
 	}
+	chunk = chunk[:n]
 
 	_, err = f.Seek(0, io.SeekStart)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("cannot read file: %s", r.URL.Path), http.StatusInternalServerError)
-		c.l.Printf("500 - could not seek within file: %s - %s", filepath.Join(c.basePath, r.URL.Path), err)
+		c.l.Printf("500 - could not seek within file: %s - %s", filepath.Join(c.basePath, urlPath), err)
 		return
 	}
 
-	w.Header().Set("Content-Type", http.DetectContentType(chunk))
-	http.ServeContent(w, r, r.URL.Path, stat.ModTime(), f)
+	w.Header().Set("Content-Type", detectContentType(c.detectors, urlPath, chunk))
 
-	return
+	var content io.ReadSeeker = f
+	modTime := stat.ModTime()
+	variant, variantStat, encoding, found := c.precompressed(urlPath, r)
+	if found {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if variant != nil {
+		defer variant.Close()
+		w.Header().Set("Content-Encoding", encoding)
+		content, modTime = variant, variantStat.ModTime()
+	}
+	http.ServeContent(w, r, urlPath, modTime, content)
 }
 
 type responseCodeHandler struct {
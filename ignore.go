@@ -0,0 +1,317 @@
+package dandler
+
+import (
+	"bufio"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultIgnore hides the same files Index always has - names starting with ".".
+// It only applies to handlers that list directories - see newListIgnorer.
+var defaultIgnore = []string{".*"}
+
+// WithIgnore adds gitignore style patterns for paths that should not be
+// listed or served. Ignored paths are left out of listings, archives and
+// thumbnails, and requests for them get a 404.
+//
+// Patterns are checked in order, and the last one to match wins:
+//
+//	*.tmp        matches a name in any directory
+//	/drafts      a pattern containing a slash is relative to the root
+//	build/       a trailing slash only matches directories
+//	docs/**/*.md ** matches any number of directories
+//	!.well-known a leading ! shows something a previous pattern hid
+//
+// Everything within an ignored directory is ignored too. Handlers that list
+// directories - Index, Archive, Search, Feed, Sitemap and the like - also hide
+// names starting with ".", before these patterns are checked - use "!.*" to
+// show them. Handlers that serve files only hide what is given here.
+func WithIgnore(patterns ...string) Option {
+	return func(o *options) {
+		o.ignore = append(o.ignore, patterns...)
+	}
+}
+
+// WithIgnoreFile reads more patterns from a file with this name in each
+// directory, as with .gitignore. Patterns in the file are relative to the
+// directory it is in, and come after those from WithIgnore and from the
+// directories above. The file itself is never served.
+func WithIgnoreFile(name string) Option {
+	return func(o *options) {
+		o.ignoreFile = name
+	}
+}
+
+// ignoreRule is a single pattern, from the directory base.
+type ignoreRule struct {
+	base     string
+	segments []string
+	anchored bool
+	dirOnly  bool
+	negate   bool
+}
+
+// parseIgnore reads patterns found in the directory base - blank lines and
+// those starting with # are skipped.
+func parseIgnore(base string, patterns []string) []ignoreRule {
+	base = strings.TrimSuffix(path.Clean("/"+base), "/") + "/"
+	var rules []ignoreRule
+	for _, pattern := range patterns {
+		pattern = strings.TrimRight(pattern, " \t\r")
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		rule := ignoreRule{base: base}
+		if strings.HasPrefix(pattern, "!") {
+			rule.negate = true
+			pattern = pattern[1:]
+		} else if strings.HasPrefix(pattern, `\`) {
+			// escapes a leading # or !
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			rule.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+		rule.anchored = strings.Contains(pattern, "/")
+		pattern = strings.TrimPrefix(pattern, "/")
+		if pattern == "" {
+			continue
+		}
+		rule.segments = strings.Split(pattern, "/")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// match reports if the rule matches p, a slash separated path from the root.
+func (r ignoreRule) match(p string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !strings.HasPrefix(p, r.base) || p == r.base {
+		return false
+	}
+	parts := strings.Split(strings.TrimPrefix(p, r.base), "/")
+	if !r.anchored {
+		matched, err := path.Match(r.segments[0], parts[len(parts)-1])
+		return err == nil && matched
+	}
+	return matchSegments(r.segments, parts)
+}
+
+// matchSegments matches a path against a pattern, a directory at a time.
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	matched, err := path.Match(pattern[0], parts[0])
+	return err == nil && matched && matchSegments(pattern[1:], parts[1:])
+}
+
+// ignorer decides which paths are ignored, using the patterns from options
+// along with any ignore files found through fs.
+type ignorer struct {
+	fs    http.FileSystem
+	file  string
+	rules []ignoreRule
+
+	lock  sync.Mutex
+	files map[string]ignoreList
+}
+
+// ignoreList holds the rules read from an ignore file, until it changes.
+type ignoreList struct {
+	modTime time.Time
+	rules   []ignoreRule
+}
+
+// newIgnorer builds an ignorer from the options. Ignore files are read from
+// fs - which may be nil if there are none.
+func newIgnorer(fs http.FileSystem, o options) *ignorer {
+	return &ignorer{
+		fs:    fs,
+		file:  o.ignoreFile,
+		rules: parseIgnore("/", o.ignore),
+		files: make(map[string]ignoreList),
+	}
+}
+
+// newListIgnorer builds an ignorer for a handler that lists directories -
+// these hide names starting with "." too, as Index always has.
+func newListIgnorer(fs http.FileSystem, o options) *ignorer {
+	o.ignore = append(append([]string(nil), defaultIgnore...), o.ignore...)
+	return newIgnorer(fs, o)
+}
+
+// ignored reports if p - a slash separated path from the root - should not be
+// listed or served.
+func (g *ignorer) ignored(p string, isDir bool) bool {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return false
+	}
+	return g.in(path.Dir(p)).ignored(path.Base(p), isDir)
+}
+
+// ignoreScope holds every rule that applies to the entries of one directory,
+// so that listing it only reads the ignore files above it once.
+type ignoreScope struct {
+	dir    string
+	file   string
+	rules  []ignoreRule
+	hidden bool
+}
+
+// in gathers the rules for the entries of dir - from the options, and from
+// the ignore files in dir and every directory above it. A nil ignorer ignores
+// nothing.
+func (g *ignorer) in(dir string) ignoreScope {
+	dir = path.Clean("/" + dir)
+	if g == nil {
+		return ignoreScope{dir: dir}
+	}
+	scope := ignoreScope{dir: "/", file: g.file, rules: g.withFile(g.rules, "/")}
+	if dir == "/" {
+		return scope
+	}
+	for _, part := range strings.Split(dir[1:], "/") {
+		// a directory being ignored hides everything within it
+		if scope.ignored(part, true) {
+			return ignoreScope{dir: dir, hidden: true}
+		}
+		scope.dir = path.Join(scope.dir, part)
+		scope.rules = g.withFile(scope.rules, scope.dir)
+	}
+	return scope
+}
+
+// withFile adds the rules from the ignore file in dir - copying, rather than
+// adding to rules shared with others.
+func (g *ignorer) withFile(rules []ignoreRule, dir string) []ignoreRule {
+	return append(rules[:len(rules):len(rules)], g.load(dir)...)
+}
+
+// ignored reports if the entry name within the scope's directory should not
+// be listed or served.
+func (s ignoreScope) ignored(name string, isDir bool) bool {
+	if s.hidden {
+		return true
+	}
+	if !isDir && s.file != "" && name == s.file {
+		return true
+	}
+	return matchRules(s.rules, path.Join(s.dir, name), isDir)
+}
+
+// matchRules reports if the last rule to match p ignores it.
+func matchRules(rules []ignoreRule, p string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.match(p, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// load returns the rules from the ignore file in dir, reading it again only
+// when it has changed.
+func (g *ignorer) load(dir string) []ignoreRule {
+	if g.fs == nil || g.file == "" {
+		return nil
+	}
+	f, err := g.fs.Open(path.Join(dir, g.file))
+	if err != nil {
+		g.lock.Lock()
+		delete(g.files, dir)
+		g.lock.Unlock()
+		return nil
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil || stat.IsDir() {
+		return nil
+	}
+
+	g.lock.Lock()
+	cached, ok := g.files[dir]
+	g.lock.Unlock()
+	if ok && cached.modTime.Equal(stat.ModTime()) {
+		return cached.rules
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	cached = ignoreList{modTime: stat.ModTime(), rules: parseIgnore(dir, lines)}
+	g.lock.Lock()
+	g.files[dir] = cached
+	g.lock.Unlock()
+	return cached.rules
+}
+
+// ignoreFS hides ignored paths within a http.FileSystem - they cannot be
+// opened, and are left out of directory listings.
+type ignoreFS struct {
+	fs      http.FileSystem
+	ignorer *ignorer
+}
+
+func (i ignoreFS) Open(name string) (http.File, error) {
+	f, err := i.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if i.ignorer.ignored(name, stat.IsDir()) {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
+	return ignoreDir{File: f, name: name, ignorer: i.ignorer}, nil
+}
+
+// ignoreDir leaves ignored entries out when a directory is listed.
+type ignoreDir struct {
+	http.File
+	name    string
+	ignorer *ignorer
+}
+
+func (d ignoreDir) Readdir(count int) ([]os.FileInfo, error) {
+	var kept []os.FileInfo
+	scope := d.ignorer.in(d.name)
+	for {
+		infos, err := d.File.Readdir(count)
+		for _, info := range infos {
+			if !scope.ignored(info.Name(), info.IsDir()) {
+				kept = append(kept, info)
+			}
+		}
+		// keep reading if everything in a batch was ignored
+		if err != nil || count <= 0 || len(kept) > 0 {
+			return kept, err
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package dandler
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnorer(t *testing.T) {
+	ignore := newListIgnorer(nil, buildOptions([]Option{WithIgnore(
+		"# comments and blank lines are skipped",
+		"",
+		"*.tmp",
+		"/drafts",
+		"build/",
+		"docs/**/*.md",
+		"!.well-known",
+		`\!bang`,
+	)}))
+
+	var testData = []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{path: "/", isDir: true, ignored: false},
+		{path: "/photo.jpg", ignored: false},
+		{path: "/.hidden", ignored: true},
+		{path: "/a/.hidden/photo.jpg", ignored: true},
+		{path: "/.well-known", isDir: true, ignored: false},
+		{path: "/.well-known/security.txt", ignored: false},
+		{path: "/notes.tmp", ignored: true},
+		{path: "/a/b/notes.tmp", ignored: true},
+		{path: "/drafts", isDir: true, ignored: true},
+		{path: "/drafts/post.md", ignored: true},
+		{path: "/a/drafts", isDir: true, ignored: false},
+		{path: "/build", isDir: true, ignored: true},
+		{path: "/a/build/out.bin", ignored: true},
+		{path: "/build", isDir: false, ignored: false},
+		{path: "/docs/readme.md", ignored: true},
+		{path: "/docs/a/b/readme.md", ignored: true},
+		{path: "/docs/readme.txt", ignored: false},
+		{path: "/a/docs/readme.md", ignored: false},
+		{path: "/!bang", ignored: true},
+	}
+	for _, test := range testData {
+		assert.Equal(t, test.ignored, ignore.ignored(test.path, test.isDir), test.path)
+	}
+}
+
+func TestIgnorer_file(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	require.NoError(t, os.MkdirAll(filepath.Join(tempdir, "a", "b"), 0755))
+	rules := filepath.Join(tempdir, "a", ".ignore")
+	require.NoError(t, ioutil.WriteFile(rules, []byte("*.raw\n/top.txt\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "a", "b", ".ignore"), []byte("!keep.raw\n"), 0644))
+
+	ignore := newIgnorer(http.Dir(tempdir), buildOptions([]Option{WithIgnore("!.*"), WithIgnoreFile(".ignore")}))
+	assert.True(t, ignore.ignored("/a/photo.raw", false))
+	assert.True(t, ignore.ignored("/a/b/photo.raw", false))
+	assert.False(t, ignore.ignored("/a/b/keep.raw", false), "deeper files take priority")
+	assert.False(t, ignore.ignored("/photo.raw", false), "rules only apply below their directory")
+	assert.True(t, ignore.ignored("/a/top.txt", false))
+	assert.False(t, ignore.ignored("/a/b/top.txt", false), "a slash anchors to the directory of the file")
+	assert.False(t, ignore.ignored("/.dotfile", false))
+	assert.True(t, ignore.ignored("/a/.ignore", false), "the ignore file is never served")
+
+	// changes to the file are picked up
+	require.NoError(t, ioutil.WriteFile(rules, []byte("*.jpg\n"), 0644))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(rules, later, later))
+	assert.False(t, ignore.ignored("/a/photo.raw", false))
+	assert.True(t, ignore.ignored("/a/photo.jpg", false))
+
+	require.NoError(t, os.Remove(rules))
+	assert.False(t, ignore.ignored("/a/photo.jpg", false))
+}
+
+// countingFS counts how many files are opened through it.
+type countingFS struct {
+	http.FileSystem
+	opened int
+}
+
+func (c *countingFS) Open(name string) (http.File, error) {
+	c.opened++
+	return c.FileSystem.Open(name)
+}
+
+func TestIgnorer_scope(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	require.NoError(t, os.MkdirAll(filepath.Join(tempdir, "a", "b"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "a", ".ignore"), []byte("*.raw\n"), 0644))
+
+	fs := &countingFS{FileSystem: http.Dir(tempdir)}
+	ignore := newIgnorer(fs, buildOptions([]Option{WithIgnoreFile(".ignore")}))
+	scope := ignore.in("/a/b")
+	opened := fs.opened
+	assert.Equal(t, 3, opened, "one ignore file for each directory")
+
+	// checking entries does not read the ignore files again
+	for i := 0; i < 100; i++ {
+		assert.True(t, scope.ignored("photo.raw", false))
+		assert.False(t, scope.ignored("photo.jpg", false))
+	}
+	assert.True(t, scope.ignored(".ignore", false))
+	assert.Equal(t, opened, fs.opened)
+
+	assert.True(t, ignore.in("/a/photo.raw").ignored("anything", false), "within an ignored directory")
+}
+
+func TestIgnore_handlers(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	for name, content := range map[string]string{
+		"shown.txt":         "shown",
+		"secret.key":        "secret",
+		".hidden":           "hidden",
+		"private/notes.txt": "private",
+	} {
+		location := filepath.Join(tempdir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(location), 0755))
+		require.NoError(t, ioutil.WriteFile(location, []byte(content), 0644))
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	opts := []Option{WithIgnore("*.key", "private/")}
+
+	get := func(h http.Handler, uri string) int {
+		ts := httptest.NewServer(h)
+		defer ts.Close()
+		res, err := http.Get(ts.URL + uri)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	files := ContentType(logger, tempdir, opts...)
+	assert.Equal(t, http.StatusOK, get(files, "/shown.txt"))
+	assert.Equal(t, http.StatusNotFound, get(files, "/secret.key"))
+	assert.Equal(t, http.StatusNotFound, get(files, "/private/notes.txt"))
+	assert.Equal(t, http.StatusOK, get(ContentType(logger, tempdir), "/secret.key"))
+	// only listings hide dotfiles by default
+	assert.Equal(t, http.StatusOK, get(files, "/.hidden"))
+	assert.Equal(t, http.StatusNotFound, get(ContentType(logger, tempdir, WithIgnore(".*")), "/.hidden"))
+
+	index := Index(logger, tempdir, done, template.Must(template.New("test").Parse("")),
+		append(opts, WithDownloads())...)
+	assert.Equal(t, http.StatusNotFound, get(index, "/private/"))
+	assert.Equal(t, http.StatusNotFound, get(index, "/private/?download=zip"))
+	assert.Equal(t, http.StatusNotFound, get(Archive(logger, tempdir, opts...), "/private/"))
+
+	ts := httptest.NewServer(index)
+	defer ts.Close()
+	res, err := http.Get(ts.URL + "/?format=json")
+	require.NoError(t, err)
+	var data IndexData
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&data))
+	res.Body.Close()
+	assert.Equal(t, []string{"/shown.txt"}, data.Files)
+	assert.Empty(t, data.Dirs)
+}
+
+func TestInternal_ignore(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Internal(logger, http.FS(embedded), WithIgnore("*.css")))
+	defer ts.Close()
+
+	for uri, code := range map[string]int{
+		"/testdata/page.html":     http.StatusOK,
+		"/testdata/page.template": http.StatusForbidden,
+		"/testdata/default.css":   http.StatusNotFound,
+	} {
+		res, err := http.Get(ts.URL + uri)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, code, res.StatusCode, uri)
+	}
+
+	res, err := http.Get(ts.URL + "/testdata/")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "page.html")
+	assert.NotContains(t, string(body), "page.template")
+	assert.NotContains(t, string(body), "default.css")
+}
@@ -1,21 +1,61 @@
 package dandler
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"html/template"
+	"image"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jakdept/dir"
 )
 
 // Index lists all files in a directory, and passes them to template execution to build a directory listing.
-// It also creates a list of directories and passes those - but symlinks to directories are not handled.
-func Index(logger *log.Logger, basepath string, done <-chan struct{}, templ *template.Template) http.Handler {
+// It also lists the directories within the requested one, with breadcrumbs
+// leading to it, and optionally a tree of every directory below it. Symlinks
+// are listed as what they lead to, as allowed by WithSymlinks - but the tree
+// only holds real directories. Paths ignored with WithIgnore or WithIgnoreFile
+// are left out, and cannot be listed.
+//
+// The functions from IndexFuncs are available to the template - configured
+// with WithSrcset. If templ is nil, the built-in "table" template is used -
+// see IndexTemplate. WithTemplates replaces templ with a TemplateSource, such
+// as a TemplateLoader, so the template can change while running.
+//
+// Each entry is described by any sidecar files found beside it - see
+// IndexMeta.
+//
+// Listings are shaped with query parameters, and the page shown is described
+// by IndexData.Page:
+//
+//	sort    name (the default), natural, mtime or size
+//	order   asc (the default) or desc
+//	filter  a glob if it contains * ? or [, otherwise a substring
+//	page    which page to show, starting at 1
+//	limit   entries per page - defaults to WithPageSize, 0 shows everything
+//
+// Given WithDownloads, ?download=zip or ?download=tar.gz sends the directory as
+// an archive instead - see Archive, and WithArchiveLimits.
+//
+// If the request asks for JSON - with ?format=json, or by preferring
+// application/json in Accept - the IndexData is sent as JSON instead.
+//
+// Rendered listings are kept in memory until something in the directory
+// changes - see WithIndexCache - and sent with an ETag and Last-Modified, so
+// that clients can check if their copy is still current.
+func Index(logger *log.Logger, basepath string, done <-chan struct{}, templ *template.Template, opts ...Option) http.Handler {
+	o := buildOptions(opts)
 	tracker, err := dir.Watch(basepath)
 	if err != nil {
 		logger.Printf("failed to watch directory [%s] - %v", basepath, err)
@@ -26,13 +66,238 @@ func Index(logger *log.Logger, basepath string, done <-chan struct{}, templ *tem
 		tracker.Close()
 	}()
 
-	return indexHandler{basePath: basepath, templ: templ, l: logger, dir: tracker, done: done}
+	binder, err := bindIndexTemplate(logger, templ, o)
+	if err != nil {
+		logger.Printf("failed to load default template - %v", err)
+		return ResponseCode(500, "failed to initialize IndexHandler - %v", err)
+	}
+
+	cache, err := newIndexCache(basepath, done, o)
+	if err != nil {
+		logger.Printf("failed to watch directory [%s] - %v", basepath, err)
+		return ResponseCode(500, "failed to initialize IndexHandler - %v", err)
+	}
+
+	var archive http.Handler
+	if o.downloads {
+		archive = Archive(logger, basepath, opts...)
+	}
+
+	return indexHandler{basePath: basepath, l: logger, dir: tracker, done: done,
+		templ: binder, pageSize: o.pageSize, events: o.events,
+		archive: archive, ignore: newListIgnorer(http.Dir(basepath), o),
+		symlinks: o.symlinks, meta: newMetaCache(logger), cache: cache}
+}
+
+// bindIndexTemplate picks the template to use from WithTemplates, or templ, or
+// the built-in "table" template - and adds the functions from IndexFuncs.
+func bindIndexTemplate(logger *log.Logger, templ *template.Template, o options) (*templateBinder, error) {
+	src := o.templates
+	if src == nil {
+		if templ == nil {
+			var err error
+			templ, err = IndexTemplate("table")
+			if err != nil {
+				return nil, err
+			}
+		}
+		src = staticTemplate{t: templ}
+	}
+	return newTemplateBinder(logger, src, o.srcset.Funcs()), nil
 }
 
-// This is the struct passed to the template used with an IndexHandler
+// This is the struct passed to the template used with an IndexHandler. It is
+// also what is sent when JSON is requested.
+//
+// Path is the directory requested, and Dirs holds the directories immediately
+// within it. Crumbs leads from the root to the requested directory, and Parent
+// links to the directory above - it is empty at the root. Tree is only filled
+// in when asked for with ?tree=1. Events links to the IndexEvents stream for
+// the directory, when set up with WithEvents. Downloads is set when the
+// directory can be downloaded as an archive - see WithDownloads. Query is the
+// search, when sent by Search.
 type IndexData struct {
-	Files []string
-	Dirs  []string
+	Path      string          `json:"path"`
+	Files     []string        `json:"files"`
+	Dirs      []string        `json:"dirs"`
+	Entries   []IndexEntry    `json:"entries"`
+	Page      IndexPage       `json:"page"`
+	Crumbs    []IndexCrumb    `json:"crumbs"`
+	Parent    string          `json:"parent,omitempty"`
+	Tree      []IndexTreeNode `json:"tree,omitempty"`
+	Events    string          `json:"events,omitempty"`
+	Downloads bool            `json:"downloads,omitempty"`
+	Query     string          `json:"query,omitempty"`
+}
+
+// IndexCrumb is one step in the path to a directory.
+type IndexCrumb struct {
+	Name string `json:"name"`
+	Href string `json:"href"`
+}
+
+// IndexTreeNode is a directory, along with every directory below it.
+type IndexTreeNode struct {
+	Name     string          `json:"name"`
+	Path     string          `json:"path"`
+	Href     string          `json:"href"`
+	Children []IndexTreeNode `json:"children,omitempty"`
+}
+
+// dirHref escapes a directory path for use in a link.
+func dirHref(p string) string {
+	return strings.TrimSuffix((&url.URL{Path: p}).EscapedPath(), "/") + "/"
+}
+
+// breadcrumbs returns the crumbs leading to the directory p.
+func breadcrumbs(p string) []IndexCrumb {
+	crumbs := []IndexCrumb{{Name: "/", Href: "/"}}
+	current := "/"
+	for _, part := range strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		current = path.Join(current, part)
+		crumbs = append(crumbs, IndexCrumb{Name: part, Href: dirHref(current)})
+	}
+	return crumbs
+}
+
+// buildTree arranges every directory below root - from dirs, as listed by
+// dir.Tracker - into a tree. Directories that are ignored, and everything below
+// them, are left out.
+func buildTree(root string, dirs []string, ignored func(string) bool) []IndexTreeNode {
+	root = path.Clean("/" + root)
+	prefix := strings.TrimSuffix(root, "/") + "/"
+
+	children := make(map[string][]string)
+	for _, each := range dirs {
+		if each == root || !strings.HasPrefix(each, prefix) {
+			continue
+		}
+		if ignored(each) {
+			continue
+		}
+		parent := path.Dir(each)
+		children[parent] = append(children[parent], each)
+	}
+
+	var build func(string) []IndexTreeNode
+	build = func(p string) []IndexTreeNode {
+		var nodes []IndexTreeNode
+		for _, child := range children[p] {
+			nodes = append(nodes, IndexTreeNode{
+				Name:     path.Base(child),
+				Path:     child,
+				Href:     dirHref(child),
+				Children: build(child),
+			})
+		}
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+		return nodes
+	}
+	return build(root)
+}
+
+// IndexEntry describes a single file or directory within a listing. Href is
+// the escaped form of Path, ready for use in a link - directories get a
+// trailing slash. Width and Height are only set for images, and IndexMeta is
+// filled in from sidecar files.
+type IndexEntry struct {
+	IndexMeta
+
+	Name        string    `json:"name"`
+	Path        string    `json:"path"`
+	Href        string    `json:"href"`
+	IsDir       bool      `json:"dir"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mtime"`
+	Ext         string    `json:"ext,omitempty"`
+	ContentType string    `json:"contentType,omitempty"`
+	Width       int       `json:"width,omitempty"`
+	Height      int       `json:"height,omitempty"`
+}
+
+// newIndexEntry builds the entry for info, found at the given path in the
+// request. Details that need the file to be read are filled in by probe.
+func newIndexEntry(urlPath string, info os.FileInfo) IndexEntry {
+	entry := IndexEntry{
+		Name:    info.Name(),
+		Path:    urlPath,
+		Href:    (&url.URL{Path: urlPath}).EscapedPath(),
+		IsDir:   info.IsDir(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if entry.IsDir {
+		entry.Href = strings.TrimSuffix(entry.Href, "/") + "/"
+		return entry
+	}
+	entry.Ext = strings.ToLower(strings.TrimPrefix(path.Ext(entry.Name), "."))
+	return entry
+}
+
+// listEntries builds the entries for the contents of the directory at
+// urlPath, leaving out sidecars, ignored paths, and symlinks the policy does
+// not allow. Every name in contents is returned too, for describing the
+// entries with a metaCache.
+func listEntries(basePath, urlPath string, contents []os.FileInfo, ignore *ignorer,
+	symlinks SymlinkPolicy) ([]IndexEntry, map[string]os.FileInfo) {
+	names := make(map[string]os.FileInfo, len(contents))
+	for _, each := range contents {
+		names[each.Name()] = each
+	}
+
+	var entries []IndexEntry
+	scope := ignore.in(urlPath)
+	for _, each := range contents {
+		if isSidecar(each.Name(), names) {
+			continue
+		}
+		entryPath := path.Join(urlPath, each.Name())
+		_, info, ok := symlinks.resolveEntry(basePath, urlPath, each)
+		if !ok || scope.ignored(each.Name(), info.IsDir()) {
+			continue
+		}
+		entries = append(entries, newIndexEntry(entryPath, info))
+	}
+	return entries, names
+}
+
+// IsImage reports if the entry was detected as an image.
+func (e IndexEntry) IsImage() bool {
+	return strings.HasPrefix(e.ContentType, "image/")
+}
+
+// probe sniffs the content type of the file at location, and if it is an
+// image, reads the dimensions from its header. The rest of the image is not
+// decoded.
+func (e *IndexEntry) probe(location string) {
+	if e.IsDir {
+		return
+	}
+	f, err := os.Open(location)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return
+	}
+	head = head[:n]
+	e.ContentType = http.DetectContentType(head)
+	if !strings.HasPrefix(e.ContentType, "image/") {
+		return
+	}
+
+	config, _, err := image.DecodeConfig(io.MultiReader(bytes.NewReader(head), f))
+	if err != nil {
+		return
+	}
+	e.Width, e.Height = config.Width, config.Height
 }
 
 type indexHandler struct {
@@ -40,16 +305,29 @@ type indexHandler struct {
 	done     <-chan struct{}
 	dir      *dir.Tracker
 	basePath string
-	templ    *template.Template
+	templ    *templateBinder
+	pageSize int
+	events   string
+	archive  http.Handler
+	ignore   *ignorer
+	symlinks SymlinkPolicy
+	meta     *metaCache
+	cache    *indexCache
 }
 
 func (c indexHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	f, err := os.Open(filepath.Join(c.basePath, r.URL.Path))
+	if c.archive != nil && r.URL.Query().Get("download") != "" {
+		c.archive.ServeHTTP(w, r)
+		return
+	}
+
+	f, err := c.symlinks.open(c.basePath, r.URL.Path)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("not found: %s", r.URL.Path), http.StatusNotFound)
 		c.l.Printf("404 - could not find file: %s - %s", filepath.Join(c.basePath, r.URL.Path), err)
 		return
 	}
+	defer f.Close()
 
 	stat, err := f.Stat()
 	if err != nil {
@@ -58,12 +336,44 @@ func (c indexHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if c.ignore.ignored(r.URL.Path, stat.IsDir()) {
+		http.Error(w, fmt.Sprintf("not found: %s", r.URL.Path), http.StatusNotFound)
+		c.l.Printf("404 - ignored: %s", filepath.Join(c.basePath, r.URL.Path))
+		return
+	}
+
 	if !stat.IsDir() {
 		http.Error(w, fmt.Sprintf("cannot read target: %s", r.URL.Path), http.StatusForbidden)
 		c.l.Printf("403 - could not stat file: %s - %s", filepath.Join(c.basePath, r.URL.Path), err)
 		return
 	}
 
+	query, err := parseListingQuery(r.URL.Query(), c.pageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		c.l.Printf("400 - bad listing query: %s - %s", r.URL.RawQuery, err)
+		return
+	}
+
+	var key, dirPath string
+	var templ *template.Template
+	var generation int
+	if !wantsJSON(r.URL.Query().Get("format"), r.Header.Get("Accept")) {
+		templ = c.templ.Template()
+	}
+	cacheable := c.cache != nil
+	if cacheable {
+		dirPath, cacheable = c.cache.dirOf(f.Name())
+	}
+	if cacheable {
+		key = listingKey(r, templ == nil)
+		if listing, ok := c.cache.get(key, templ, stat.ModTime()); ok {
+			listing.serve(w, r)
+			return
+		}
+		generation = c.cache.current()
+	}
+
 	contents, err := f.Readdir(0)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("cannot read directory: %s", r.URL.Path), http.StatusForbidden)
@@ -71,24 +381,76 @@ func (c indexHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var data IndexData
-	data.Dirs = c.dir.List()
+	data := IndexData{Path: path.Clean("/" + r.URL.Path)}
+	if c.events != "" {
+		data.Events = c.events + dirHref(data.Path)
+	}
+	data.Downloads = c.archive != nil
+	data.Crumbs = breadcrumbs(r.URL.Path)
+	if len(data.Crumbs) > 1 {
+		data.Parent = data.Crumbs[len(data.Crumbs)-2].Href
+	}
+	if tree, _ := strconv.ParseBool(r.URL.Query().Get("tree")); tree {
+		data.Tree = buildTree(r.URL.Path, c.dir.List(), func(p string) bool {
+			return c.ignore.ignored(p, true)
+		})
+	}
 
-	for _, each := range contents {
-		if !each.IsDir() {
-			// suppress directories
-			if !strings.HasPrefix(each.Name(), ".") {
-				// suppress hidden files
-				data.Files = append(data.Files, path.Join(r.URL.Path, each.Name()))
-			}
+	entries, names := listEntries(c.basePath, r.URL.Path, contents, c.ignore, c.symlinks)
+	data.Entries, data.Page = query.apply(entries, r.URL)
+	c.meta.describe(f.Name(), names, data.Entries)
+	for i := range data.Entries {
+		// only the entries shown are worth opening
+		data.Entries[i].probe(filepath.Join(c.basePath, filepath.FromSlash(data.Entries[i].Path)))
+		if data.Entries[i].IsDir {
+			data.Dirs = append(data.Dirs, data.Entries[i].Path)
+		} else {
+			data.Files = append(data.Files, data.Entries[i].Path)
 		}
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	err = c.templ.Execute(w, data)
+	if !cacheable {
+		renderIndex(w, r, c.l, c.templ, data)
+		return
+	}
+	listing, err := newCachedListing(templ, data, stat.ModTime(), entries)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("error building response: %s", r.URL.Path), http.StatusInternalServerError)
 		c.l.Printf("500 - error responding: %s", err)
 		return
 	}
+	listing.dir, listing.tree = dirPath, data.Tree != nil
+	c.cache.put(key, generation, listing)
+	listing.serve(w, r)
+}
+
+// renderIndex sends data as JSON if the request asked for it, otherwise as
+// HTML built with the template.
+func renderIndex(w http.ResponseWriter, r *http.Request, l *log.Logger, templ *templateBinder, data IndexData) {
+	w.Header().Add("Vary", "Accept")
+
+	var t *template.Template
+	if !wantsJSON(r.URL.Query().Get("format"), r.Header.Get("Accept")) {
+		t = templ.Template()
+	}
+	body, contentType, err := buildIndex(t, data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error building response: %s", r.URL.Path), http.StatusInternalServerError)
+		l.Printf("500 - error responding: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+// buildIndex renders data with templ - or as JSON, if templ is nil - and
+// returns it with its content type.
+func buildIndex(templ *template.Template, data IndexData) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if templ == nil {
+		err := json.NewEncoder(&buf).Encode(data)
+		return buf.Bytes(), "application/json; charset=utf-8", err
+	}
+	err := templ.Execute(&buf, data)
+	return buf.Bytes(), "text/html; charset=utf-8", err
 }
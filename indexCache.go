@@ -0,0 +1,181 @@
+package dandler
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithIndexCache sets how many rendered listings Index keeps in memory - one
+// for each directory, query and format requested. Listings are dropped when
+// anything in their directory changes, and are not used once the directory's
+// mtime differs from when they were built - in case a change was missed.
+// Passing 0 turns the cache off.
+func WithIndexCache(entries int) Option {
+	return func(o *options) {
+		o.indexCache = entries
+	}
+}
+
+// cachedListing is a rendered listing, ready to be sent again.
+type cachedListing struct {
+	dir         string
+	tree        bool
+	templ       *template.Template
+	body        []byte
+	contentType string
+	etag        string
+	modTime     time.Time
+	dirModTime  time.Time
+}
+
+// indexCache holds rendered listings, keyed by the request that built them.
+// Each listing is kept with the directory it lists - relative to the real
+// location being watched, as changes are reported.
+type indexCache struct {
+	max      int
+	realBase string
+	ignore   string
+
+	lock       sync.Mutex
+	generation int
+	listings   map[string]cachedListing
+}
+
+// newIndexCache starts watching basepath, dropping listings as their
+// directories change. Without a watch, nothing can be cached.
+func newIndexCache(basepath string, done <-chan struct{}, o options) (*indexCache, error) {
+	if o.indexCache < 1 {
+		return nil, nil
+	}
+	w, err := watchChanges(basepath, done)
+	if err != nil {
+		return nil, err
+	}
+	c := &indexCache{
+		max:      o.indexCache,
+		realBase: w.basepath,
+		ignore:   o.ignoreFile,
+		listings: make(map[string]cachedListing),
+	}
+	w.subscribe(c.invalidate)
+	return c, nil
+}
+
+// listingKey identifies a listing by the path requested, the query - in a
+// stable order - and if it was sent as JSON.
+func listingKey(r *http.Request, asJSON bool) string {
+	return fmt.Sprintf("%t %s?%s", asJSON, path.Clean("/"+r.URL.Path), r.URL.Query().Encode())
+}
+
+// dirOf returns the directory at location as the watcher names it - or false
+// if it is outside of what is watched, such as through a symlink.
+func (c *indexCache) dirOf(location string) (string, bool) {
+	real, err := filepath.Abs(location)
+	if err != nil {
+		return "", false
+	}
+	real, err = filepath.EvalSymlinks(real)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(c.realBase, real)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return path.Clean("/" + filepath.ToSlash(rel)), true
+}
+
+// get returns the listing stored for key, as long as it was built with the
+// template in use now, and the directory has the same mtime it had then.
+func (c *indexCache) get(key string, templ *template.Template, dirMod time.Time) (cachedListing, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	listing, ok := c.listings[key]
+	if !ok || listing.templ != templ || !listing.dirModTime.Equal(dirMod) {
+		return cachedListing{}, false
+	}
+	return listing, true
+}
+
+// current returns a marker to pass to put - a listing built after a change
+// has started is not stored.
+func (c *indexCache) current() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.generation
+}
+
+func (c *indexCache) put(key string, generation int, listing cachedListing) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if _, ok := c.listings[key]; !ok && len(c.listings) >= c.max {
+		// any listing will do - they are cheap to build again
+		for each := range c.listings {
+			delete(c.listings, each)
+			break
+		}
+	}
+	c.listings[key] = listing
+}
+
+// invalidate drops every listing a change could show up in - the directory it
+// happened in, the one above that (which shows the directory's mtime and
+// size), anything below a changed directory, and everything an ignore file
+// applies to. Trees change whenever a path is added or removed.
+func (c *indexCache) invalidate(ch change) {
+	parent := path.Dir(ch.Path)
+	below := strings.TrimSuffix(ch.Path, "/") + "/"
+	if c.ignore != "" && path.Base(ch.Path) == c.ignore {
+		below = strings.TrimSuffix(parent, "/") + "/"
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.generation++
+	for key, listing := range c.listings {
+		if listing.dir == parent || listing.dir == path.Dir(parent) ||
+			listing.dir == ch.Path || strings.HasPrefix(listing.dir+"/", below) ||
+			(listing.tree && ch.Op != changeWrite) {
+			delete(c.listings, key)
+		}
+	}
+}
+
+// newCachedListing renders data - with templ, or as JSON if templ is nil - and
+// tags it to be checked by clients later. The listing is as new as the newest
+// entry in it, or the directory itself.
+func newCachedListing(templ *template.Template, data IndexData, dirMod time.Time,
+	entries []IndexEntry) (cachedListing, error) {
+	listing := cachedListing{templ: templ, modTime: dirMod, dirModTime: dirMod}
+	var err error
+	listing.body, listing.contentType, err = buildIndex(templ, data)
+	if err != nil {
+		return listing, err
+	}
+	listing.etag = fmt.Sprintf(`"%x"`, sha1.Sum(listing.body))
+	for _, entry := range entries {
+		if entry.ModTime.After(listing.modTime) {
+			listing.modTime = entry.ModTime
+		}
+	}
+	return listing, nil
+}
+
+// serve sends the listing - or 304 Not Modified, if the client has it.
+func (listing cachedListing) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", listing.contentType)
+	w.Header().Set("ETag", listing.etag)
+	http.ServeContent(w, r, r.URL.Path, listing.modTime, bytes.NewReader(listing.body))
+}
@@ -0,0 +1,172 @@
+package dandler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex_cache(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	require.NoError(t, os.MkdirAll(filepath.Join(tempdir, "sub"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "sub", "a.txt"), []byte("ohai"), 0644))
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(tempdir, "sub", "a.txt"), mtime, mtime))
+	require.NoError(t, os.Chtimes(filepath.Join(tempdir, "sub"), mtime, mtime))
+
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Index(logger, tempdir, done, nil))
+	defer ts.Close()
+
+	get := func(uri, etag string) (*http.Response, []string) {
+		req, err := http.NewRequest("GET", ts.URL+uri, nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", "application/json")
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		files := []string{}
+		if res.StatusCode == http.StatusOK && res.Header.Get("Content-Type") == "application/json; charset=utf-8" {
+			var data IndexData
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&data))
+			files = append(files, data.Files...)
+		}
+		return res, files
+	}
+
+	res, files := get("/sub/", "")
+	assert.Equal(t, []string{"/sub/a.txt"}, files)
+	etag := res.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+	assert.Equal(t, "Wed, 01 Jan 2020 00:00:00 GMT", res.Header.Get("Last-Modified"))
+	assert.Equal(t, "application/json; charset=utf-8", res.Header.Get("Content-Type"))
+
+	res, _ = get("/sub/", etag)
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+	res, _ = get("/sub/?format=html", etag)
+	assert.Equal(t, http.StatusOK, res.StatusCode, "each format is cached apart")
+
+	// the listing is built again once the directory changes
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "sub", "b.txt"), []byte("ohai"), 0644))
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, files = get("/sub/", ""); len(files) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	res, files = get("/sub/", etag)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []string{"/sub/a.txt", "/sub/b.txt"}, files)
+	assert.NotEqual(t, etag, res.Header.Get("ETag"))
+}
+
+func TestIndex_cacheRelative(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Index(logger, "testdata/sample_images", done, nil))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	require.NoError(t, err)
+	res.Body.Close()
+	etag := res.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", etag)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+}
+
+func TestIndex_withoutCache(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Index(logger, "testdata/sample_images", done, nil, WithIndexCache(0)))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Empty(t, res.Header.Get("ETag"))
+}
+
+func TestIndexCache_invalidate(t *testing.T) {
+	c := &indexCache{max: 10, ignore: ".ignore", listings: make(map[string]cachedListing)}
+	fill := func() {
+		for _, dir := range []string{"/", "/a", "/a/b", "/a/b/c", "/ab", "/x"} {
+			c.put(dir, c.current(), cachedListing{dir: dir})
+		}
+		c.put("tree", c.current(), cachedListing{dir: "/x", tree: true})
+	}
+	kept := func() []string {
+		dirs := []string{}
+		for key := range c.listings {
+			dirs = append(dirs, key)
+		}
+		sort.Strings(dirs)
+		return dirs
+	}
+
+	var testData = []struct {
+		change   change
+		expected []string
+	}{
+		{change: change{Op: changeWrite, Path: "/a/b/file"},
+			expected: []string{"/", "/a/b/c", "/ab", "/x", "tree"}},
+		{change: change{Op: changeAdd, Path: "/a/b/file"},
+			expected: []string{"/", "/a/b/c", "/ab", "/x"}},
+		{change: change{Op: changeRemove, Path: "/a"},
+			expected: []string{"/ab", "/x"}},
+		{change: change{Op: changeWrite, Path: "/a/.ignore"},
+			expected: []string{"/ab", "/x", "tree"}},
+	}
+	for _, test := range testData {
+		c.listings = make(map[string]cachedListing)
+		fill()
+		c.invalidate(test.change)
+		assert.Equal(t, test.expected, kept(), test.change.Path)
+	}
+
+	// listings built while a change happens are not kept
+	generation := c.current()
+	c.invalidate(change{Op: changeWrite, Path: "/x/file"})
+	c.put("/x", generation, cachedListing{dir: "/x"})
+	assert.NotContains(t, c.listings, "/x")
+}
+
+func TestIndexCache_dirModTime(t *testing.T) {
+	c := &indexCache{max: 10, listings: make(map[string]cachedListing)}
+	built := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.put("/a", c.current(), cachedListing{dir: "/a", dirModTime: built})
+
+	_, ok := c.get("/a", nil, built)
+	assert.True(t, ok)
+
+	// a change the watcher missed still shows in the directory's mtime
+	_, ok = c.get("/a", nil, built.Add(time.Second))
+	assert.False(t, ok)
+}
@@ -5,15 +5,20 @@
 package dandler
 
 import (
+	"encoding/json"
 	"html/template"
+	"image"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/sebdah/goldie"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func init() {
@@ -100,3 +105,159 @@ func TestIndex_badtemplate(t *testing.T) {
 
 	assert.Equal(t, 500, res.StatusCode, "got wrong response")
 }
+
+func TestIndex_json(t *testing.T) {
+	testTempl := template.Must(template.New("test").Parse("html"))
+
+	done := make(chan struct{})
+	defer close(done)
+
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Index(logger, "testdata/sample_images", done, testTempl))
+	defer ts.Close()
+
+	for _, accept := range []string{"", "text/html"} {
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", accept)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+	}
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", ts.URL+"/edat/?format=json", nil),
+		httptest.NewRequest("GET", ts.URL+"/edat/", nil),
+	} {
+		req.RequestURI = ""
+		if req.URL.RawQuery == "" {
+			req.Header.Set("Accept", "application/json")
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, "application/json; charset=utf-8", res.Header.Get("Content-Type"))
+		assert.Equal(t, "Accept", res.Header.Get("Vary"))
+
+		var data IndexData
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&data))
+		res.Body.Close()
+		assert.Equal(t, []string{"/edat/placehold"}, data.Files)
+		require.Len(t, data.Entries, 1)
+		assert.Equal(t, "placehold", data.Entries[0].Name)
+		assert.Equal(t, "/edat/placehold", data.Entries[0].Path)
+		assert.False(t, data.Entries[0].IsDir)
+		assert.False(t, data.Entries[0].ModTime.IsZero())
+	}
+}
+
+func TestIndex_metadata(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	writeTestPNG(t, filepath.Join(tempdir, "a b.png"), image.NewGray(image.Rect(0, 0, 16, 8)))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "notes.TXT"), []byte("ohai"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(tempdir, "sub dir"), 0755))
+
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Index(logger, tempdir, done, template.Must(template.New("test").Parse(""))))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "?format=json")
+	require.NoError(t, err)
+	var data IndexData
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&data))
+	res.Body.Close()
+
+	require.Len(t, data.Entries, 3)
+	img, txt, sub := data.Entries[0], data.Entries[1], data.Entries[2]
+
+	assert.Equal(t, "/a%20b.png", img.Href)
+	assert.Equal(t, "png", img.Ext)
+	assert.Equal(t, "image/png", img.ContentType)
+	assert.Equal(t, 16, img.Width)
+	assert.Equal(t, 8, img.Height)
+
+	assert.Equal(t, "/notes.TXT", txt.Href)
+	assert.Equal(t, "txt", txt.Ext)
+	assert.Equal(t, int64(4), txt.Size)
+	assert.Equal(t, "text/plain; charset=utf-8", txt.ContentType)
+	assert.Zero(t, txt.Width)
+
+	assert.Equal(t, "/sub%20dir/", sub.Href)
+	assert.True(t, sub.IsDir)
+	assert.Empty(t, sub.ContentType)
+}
+
+func TestBreadcrumbs(t *testing.T) {
+	assert.Equal(t, []IndexCrumb{{Name: "/", Href: "/"}}, breadcrumbs("/"))
+	assert.Equal(t, []IndexCrumb{
+		{Name: "/", Href: "/"},
+		{Name: "a b", Href: "/a%20b/"},
+		{Name: "c", Href: "/a%20b/c/"},
+	}, breadcrumbs("/a b/c/"))
+}
+
+func TestBuildTree(t *testing.T) {
+	dirs := []string{"/", "/a", "/a/b", "/a/b/c", "/a/.hidden", "/a/.hidden/d", "/z"}
+	ignore := newListIgnorer(nil, buildOptions(nil))
+	ignored := func(p string) bool { return ignore.ignored(p, true) }
+	assert.Equal(t, []IndexTreeNode{
+		{Name: "a", Path: "/a", Href: "/a/", Children: []IndexTreeNode{
+			{Name: "b", Path: "/a/b", Href: "/a/b/", Children: []IndexTreeNode{
+				{Name: "c", Path: "/a/b/c", Href: "/a/b/c/"},
+			}},
+		}},
+		{Name: "z", Path: "/z", Href: "/z/"},
+	}, buildTree("/", dirs, ignored))
+	assert.Equal(t, []IndexTreeNode{
+		{Name: "c", Path: "/a/b/c", Href: "/a/b/c/"},
+	}, buildTree("/a/b", dirs, ignored))
+	assert.Empty(t, buildTree("/z", dirs, ignored))
+}
+
+func TestIndex_subdirectories(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	for _, each := range []string{"a/b/c", "a/d", "e"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(tempdir, each), 0755))
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Index(logger, tempdir, done, template.Must(template.New("test").Parse(""))))
+	defer ts.Close()
+
+	get := func(uri string) IndexData {
+		res, err := http.Get(ts.URL + uri)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		var data IndexData
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&data))
+		return data
+	}
+
+	data := get("/?format=json")
+	assert.Equal(t, []string{"/a", "/e"}, data.Dirs)
+	assert.Equal(t, "", data.Parent)
+	assert.Empty(t, data.Tree)
+
+	data = get("/a/?format=json&tree=1")
+	assert.Equal(t, []string{"/a/b", "/a/d"}, data.Dirs)
+	assert.Equal(t, "/", data.Parent)
+	assert.Equal(t, []IndexCrumb{{Name: "/", Href: "/"}, {Name: "a", Href: "/a/"}}, data.Crumbs)
+	assert.Equal(t, []IndexTreeNode{
+		{Name: "b", Path: "/a/b", Href: "/a/b/", Children: []IndexTreeNode{
+			{Name: "c", Path: "/a/b/c", Href: "/a/b/c/"},
+		}},
+		{Name: "d", Path: "/a/d", Href: "/a/d/"},
+	}, data.Tree)
+
+	data = get("/a/b/?format=json")
+	assert.Equal(t, []string{"/a/b/c"}, data.Dirs)
+	assert.Equal(t, "/a/", data.Parent)
+}
@@ -10,8 +10,13 @@ import (
 // Internal serves a static, in memory filesystem. The filesystem to be
 // served should have been generated with embed or similar..
 //
+// Files ending in .template are refused with a 403, and left out of listings.
+// Anything ignored with WithIgnore or WithIgnoreFile is not served, or listed.
+//
 // Note - this is likely a duplicate of http.FileServer. It will likely be removed.
-func Internal(logger *log.Logger, fs http.FileSystem) http.Handler {
+func Internal(logger *log.Logger, fs http.FileSystem, opts ...Option) http.Handler {
+	o := buildOptions(append([]Option{WithIgnore("*.template")}, opts...))
+	fs = ignoreFS{fs: fs, ignorer: newIgnorer(fs, o)}
 	return internalHandler{handler: http.FileServer(fs), l: logger}
 }
 
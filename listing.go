@@ -0,0 +1,196 @@
+package dandler
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WithPageSize sets how many entries Index shows per page when the request
+// does not give a limit. 0 - the default - shows everything.
+func WithPageSize(n int) Option {
+	return func(o *options) {
+		o.pageSize = n
+	}
+}
+
+// IndexPage describes which part of a listing is shown. Next and Prev are
+// links to the neighboring pages, and are empty where there is no such page.
+type IndexPage struct {
+	Page  int    `json:"page"`
+	Pages int    `json:"pages"`
+	Limit int    `json:"limit"`
+	Total int    `json:"total"`
+	Next  string `json:"next,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+}
+
+// listingQuery holds the query parameters that shape a listing - they are
+// described with Index.
+type listingQuery struct {
+	sort   string
+	desc   bool
+	filter string
+	page   int
+	limit  int
+}
+
+func parseListingQuery(values url.Values, defaultLimit int) (listingQuery, error) {
+	q := listingQuery{sort: "name", page: 1, limit: defaultLimit, filter: values.Get("filter")}
+
+	switch s := values.Get("sort"); s {
+	case "":
+	case "name", "natural", "mtime", "size":
+		q.sort = s
+	default:
+		return q, fmt.Errorf("unknown sort: %s", s)
+	}
+
+	switch o := values.Get("order"); o {
+	case "", "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return q, fmt.Errorf("unknown order: %s", o)
+	}
+
+	if raw := values.Get("page"); raw != "" {
+		page, err := strconv.Atoi(raw)
+		if err != nil || page < 1 {
+			return q, fmt.Errorf("bad page: %s", raw)
+		}
+		q.page = page
+	}
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 0 {
+			return q, fmt.Errorf("bad limit: %s", raw)
+		}
+		q.limit = limit
+	}
+
+	if _, err := path.Match(strings.ToLower(q.filter), ""); err != nil {
+		return q, fmt.Errorf("bad filter: %s", q.filter)
+	}
+	return q, nil
+}
+
+// matches reports if name passes the filter. Matching ignores case.
+func (q listingQuery) matches(name string) bool {
+	if q.filter == "" {
+		return true
+	}
+	name, filter := strings.ToLower(name), strings.ToLower(q.filter)
+	if strings.ContainsAny(filter, "*?[") {
+		ok, _ := path.Match(filter, name)
+		return ok
+	}
+	return strings.Contains(name, filter)
+}
+
+// apply filters, sorts and pages entries. The page of entries and a
+// description of the page are returned - links are relative to u.
+func (q listingQuery) apply(entries []IndexEntry, u *url.URL) ([]IndexEntry, IndexPage) {
+	filtered := make([]IndexEntry, 0, len(entries))
+	for _, entry := range entries {
+		if q.matches(entry.Name) {
+			filtered = append(filtered, entry)
+		}
+	}
+
+	var less func(a, b IndexEntry) bool
+	switch q.sort {
+	case "relevance":
+		// already in order of relevance - only set by Search
+		less = func(a, b IndexEntry) bool { return false }
+	case "natural":
+		less = func(a, b IndexEntry) bool { return naturalLess(a.Name, b.Name) }
+	case "mtime":
+		less = func(a, b IndexEntry) bool { return a.ModTime.Before(b.ModTime) }
+	case "size":
+		less = func(a, b IndexEntry) bool { return a.Size < b.Size }
+	default:
+		less = func(a, b IndexEntry) bool { return a.Name < b.Name }
+	}
+	sort.SliceStable(filtered, func(i, j int) bool {
+		if q.desc {
+			return less(filtered[j], filtered[i])
+		}
+		return less(filtered[i], filtered[j])
+	})
+
+	page := IndexPage{Page: q.page, Pages: 1, Limit: q.limit, Total: len(filtered)}
+	if q.limit == 0 {
+		if q.page > 1 {
+			return []IndexEntry{}, page
+		}
+		return filtered, page
+	}
+
+	page.Pages = (len(filtered) + q.limit - 1) / q.limit
+	if page.Pages == 0 {
+		page.Pages = 1
+	}
+	if q.page < page.Pages {
+		page.Next = pageLink(u, q.page+1)
+	}
+	if q.page > 1 {
+		page.Prev = pageLink(u, q.page-1)
+		if q.page > page.Pages {
+			page.Prev = pageLink(u, page.Pages)
+		}
+	}
+
+	start := (q.page - 1) * q.limit
+	if start >= len(filtered) {
+		return []IndexEntry{}, page
+	}
+	end := start + q.limit
+	if end > len(filtered) {
+		end = len(filtered)
+	}
+	return filtered[start:end], page
+}
+
+// pageLink returns a link to the given page, keeping the rest of the query.
+func pageLink(u *url.URL, page int) string {
+	values := u.Query()
+	values.Set("page", strconv.Itoa(page))
+	return (&url.URL{Path: u.Path, RawQuery: values.Encode()}).String()
+}
+
+// naturalLess compares strings so that runs of digits are ordered by their
+// value - so "img2" comes before "img10".
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		aDigits, bDigits := leadingDigits(a), leadingDigits(b)
+		if aDigits != "" && bDigits != "" {
+			aNum, bNum := strings.TrimLeft(aDigits, "0"), strings.TrimLeft(bDigits, "0")
+			if len(aNum) != len(bNum) {
+				return len(aNum) < len(bNum)
+			}
+			if aNum != bNum {
+				return aNum < bNum
+			}
+			a, b = a[len(aDigits):], b[len(bDigits):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func leadingDigits(s string) string {
+	for i, r := range s {
+		if r < '0' || r > '9' {
+			return s[:i]
+		}
+	}
+	return s
+}
@@ -0,0 +1,156 @@
+package dandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNaturalLess(t *testing.T) {
+	names := []string{"img10.jpg", "img2.jpg", "img1.jpg", "img02b.jpg", "alpha", "img", "img10a.jpg"}
+	sort.Slice(names, func(i, j int) bool { return naturalLess(names[i], names[j]) })
+	assert.Equal(t, []string{"alpha", "img", "img1.jpg", "img2.jpg", "img02b.jpg", "img10.jpg", "img10a.jpg"}, names)
+}
+
+func TestParseListingQuery(t *testing.T) {
+	testData := []struct {
+		query string
+		err   bool
+		want  listingQuery
+	}{
+		{query: "", want: listingQuery{sort: "name", page: 1, limit: 5}},
+		{query: "sort=mtime&order=desc&page=3&limit=0&filter=*.jpg",
+			want: listingQuery{sort: "mtime", desc: true, page: 3, limit: 0, filter: "*.jpg"}},
+		{query: "sort=color", err: true},
+		{query: "order=sideways", err: true},
+		{query: "page=0", err: true},
+		{query: "limit=-1", err: true},
+		{query: "filter=[", err: true},
+	}
+	for id, test := range testData {
+		values, err := url.ParseQuery(test.query)
+		require.NoError(t, err)
+		q, err := parseListingQuery(values, 5)
+		if test.err {
+			assert.Error(t, err, "#%d", id)
+			continue
+		}
+		assert.NoError(t, err, "#%d", id)
+		assert.Equal(t, test.want, q, "#%d", id)
+	}
+}
+
+func TestListingQuery_apply(t *testing.T) {
+	now := time.Now()
+	entries := []IndexEntry{
+		{Name: "b10.jpg", Size: 30, ModTime: now},
+		{Name: "b9.jpg", Size: 10, ModTime: now.Add(-time.Hour)},
+		{Name: "a.png", Size: 20, ModTime: now.Add(time.Hour)},
+		{Name: "notes.txt", Size: 40, ModTime: now.Add(-2 * time.Hour)},
+	}
+	names := func(entries []IndexEntry) []string {
+		var out []string
+		for _, e := range entries {
+			out = append(out, e.Name)
+		}
+		return out
+	}
+	u, _ := url.Parse("/dir/?sort=size&limit=2")
+
+	testData := []struct {
+		q     listingQuery
+		names []string
+		page  IndexPage
+	}{
+		{
+			q:     listingQuery{sort: "name", page: 1},
+			names: []string{"a.png", "b10.jpg", "b9.jpg", "notes.txt"},
+			page:  IndexPage{Page: 1, Pages: 1, Total: 4},
+		}, {
+			q:     listingQuery{sort: "natural", page: 1},
+			names: []string{"a.png", "b9.jpg", "b10.jpg", "notes.txt"},
+			page:  IndexPage{Page: 1, Pages: 1, Total: 4},
+		}, {
+			q:     listingQuery{sort: "mtime", desc: true, page: 1},
+			names: []string{"a.png", "b10.jpg", "b9.jpg", "notes.txt"},
+			page:  IndexPage{Page: 1, Pages: 1, Total: 4},
+		}, {
+			q:     listingQuery{sort: "size", page: 1, limit: 2},
+			names: []string{"b9.jpg", "a.png"},
+			page: IndexPage{Page: 1, Pages: 2, Limit: 2, Total: 4,
+				Next: "/dir/?limit=2&page=2&sort=size"},
+		}, {
+			q:     listingQuery{sort: "size", page: 2, limit: 2},
+			names: []string{"b10.jpg", "notes.txt"},
+			page: IndexPage{Page: 2, Pages: 2, Limit: 2, Total: 4,
+				Prev: "/dir/?limit=2&page=1&sort=size"},
+		}, {
+			q:    listingQuery{sort: "size", page: 5, limit: 2},
+			page: IndexPage{Page: 5, Pages: 2, Limit: 2, Total: 4, Prev: "/dir/?limit=2&page=2&sort=size"},
+		}, {
+			q:     listingQuery{sort: "name", page: 1, filter: "*.JPG"},
+			names: []string{"b10.jpg", "b9.jpg"},
+			page:  IndexPage{Page: 1, Pages: 1, Total: 2},
+		}, {
+			q:     listingQuery{sort: "name", page: 1, filter: "Note"},
+			names: []string{"notes.txt"},
+			page:  IndexPage{Page: 1, Pages: 1, Total: 1},
+		},
+	}
+	for id, test := range testData {
+		out, page := test.q.apply(entries, u)
+		assert.Equal(t, test.names, names(out), "#%d", id)
+		assert.Equal(t, test.page, page, "#%d", id)
+	}
+}
+
+func TestIndex_paging(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	for i := 1; i <= 12; i++ {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, fmt.Sprintf("img%d.txt", i)), nil, 0644))
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	templ := template.Must(template.New("test").Parse(`{{ range .Files }}{{ . }} {{ end }}{{ .Page.Next }}`))
+	ts := httptest.NewServer(Index(logger, tempdir, done, templ, WithPageSize(5)))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/?sort=natural&order=desc")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "/img12.txt /img11.txt /img10.txt /img9.txt /img8.txt /?order=desc&amp;page=2&amp;sort=natural",
+		string(body))
+
+	res, err = http.Get(ts.URL + "/?sort=natural&page=3&format=json")
+	require.NoError(t, err)
+	var data IndexData
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&data))
+	res.Body.Close()
+	assert.Equal(t, []string{"/img11.txt", "/img12.txt"}, data.Files)
+	assert.Equal(t, IndexPage{Page: 3, Pages: 3, Limit: 5, Total: 12,
+		Prev: "/?format=json&page=2&sort=natural"}, data.Page)
+
+	res, err = http.Get(ts.URL + "/?sort=colour")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
@@ -0,0 +1,163 @@
+package dandler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// IndexMeta describes a file in words, for the templates used by Index. It is
+// read from sidecar files beside the file being described:
+//
+//	photo.jpg.yaml     (or .yml, or .json) describes photo.jpg
+//	.meta.yaml         (or .yml, or .json) describes any file in the directory,
+//	                   as a map from file name to IndexMeta
+//
+// When both describe a file, the fields set in the file's own sidecar win.
+// Sidecars are not listed themselves, and are read again when they change.
+type IndexMeta struct {
+	Title       string   `json:"title,omitempty" yaml:"title"`
+	Description string   `json:"description,omitempty" yaml:"description"`
+	Tags        []string `json:"tags,omitempty" yaml:"tags"`
+	Alt         string   `json:"alt,omitempty" yaml:"alt"`
+}
+
+// sidecarExts are the extensions a sidecar can have, in order of preference.
+var sidecarExts = []string{".yaml", ".yml", ".json"}
+
+// manifestName is the name of the sidecar for a whole directory, without the
+// extension.
+const manifestName = ".meta"
+
+// merge fills in the fields of m that are not set from other.
+func (m IndexMeta) merge(other IndexMeta) IndexMeta {
+	if m.Title == "" {
+		m.Title = other.Title
+	}
+	if m.Description == "" {
+		m.Description = other.Description
+	}
+	if len(m.Tags) == 0 {
+		m.Tags = other.Tags
+	}
+	if m.Alt == "" {
+		m.Alt = other.Alt
+	}
+	return m
+}
+
+// isSidecar reports if name is a sidecar, given everything in its directory.
+func isSidecar(name string, names map[string]os.FileInfo) bool {
+	for _, ext := range sidecarExts {
+		if name == manifestName+ext {
+			return true
+		}
+		if target := strings.TrimSuffix(name, ext); target != name {
+			if _, ok := names[target]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// metaCache holds decoded sidecars until they change.
+type metaCache struct {
+	l     *log.Logger
+	lock  sync.Mutex
+	files map[string]cachedMeta
+}
+
+// cachedMeta is a decoded sidecar, mapping file names to their metadata.
+type cachedMeta struct {
+	modTime time.Time
+	size    int64
+	meta    map[string]IndexMeta
+}
+
+func newMetaCache(logger *log.Logger) *metaCache {
+	return &metaCache{l: logger, files: make(map[string]cachedMeta)}
+}
+
+// describe fills in the metadata for each entry, from the sidecars found in
+// the directory at location. names holds everything within the directory.
+func (m *metaCache) describe(location string, names map[string]os.FileInfo, entries []IndexEntry) {
+	var manifest map[string]IndexMeta
+	for _, ext := range sidecarExts {
+		if info, ok := names[manifestName+ext]; ok {
+			manifest = m.load(filepath.Join(location, info.Name()), info, "")
+			break
+		}
+	}
+
+	for i := range entries {
+		var meta IndexMeta
+		for _, ext := range sidecarExts {
+			if info, ok := names[entries[i].Name+ext]; ok {
+				meta = m.load(filepath.Join(location, info.Name()), info, entries[i].Name)[entries[i].Name]
+				break
+			}
+		}
+		entries[i].IndexMeta = meta.merge(manifest[entries[i].Name])
+	}
+}
+
+// load decodes the sidecar at location. A sidecar for a single file is stored
+// under target - for a manifest, target is empty.
+func (m *metaCache) load(location string, info os.FileInfo, target string) map[string]IndexMeta {
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	m.lock.Lock()
+	cached, ok := m.files[location]
+	m.lock.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.meta
+	}
+
+	// a broken sidecar is remembered too, so it is only logged once per change
+	cached = cachedMeta{modTime: info.ModTime(), size: info.Size()}
+	meta, err := decodeSidecar(location, target)
+	if err != nil {
+		m.l.Printf("could not read metadata: %s - %s", location, err)
+	} else {
+		cached.meta = meta
+	}
+
+	m.lock.Lock()
+	m.files[location] = cached
+	m.lock.Unlock()
+	return cached.meta
+}
+
+func decodeSidecar(location, target string) (map[string]IndexMeta, error) {
+	raw, err := ioutil.ReadFile(location)
+	if err != nil {
+		return nil, err
+	}
+	unmarshal := yaml.Unmarshal
+	if filepath.Ext(location) == ".json" {
+		unmarshal = json.Unmarshal
+	}
+
+	if target != "" {
+		var meta IndexMeta
+		if err := unmarshal(raw, &meta); err != nil {
+			return nil, err
+		}
+		return map[string]IndexMeta{target: meta}, nil
+	}
+	var manifest map[string]IndexMeta
+	if err := unmarshal(raw, &manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
@@ -0,0 +1,96 @@
+package dandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex_sidecars(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+
+	for name, content := range map[string]string{
+		"beach.jpg":      "not really a jpeg",
+		"beach.jpg.yaml": "title: At the beach\ntags: [sand, sea]\n",
+		"dog.png":        "not really a png",
+		"dog.png.json":   `{"title": "Good dog", "alt": "a dog, sitting"}`,
+		"cat.gif":        "not really a gif",
+		"broken.txt":     "words",
+		"broken.txt.yml": "title: [unclosed",
+		"orphan.yaml":    "not a sidecar, as there is no orphan",
+		".meta.yaml": `beach.jpg:
+  title: Overridden by the file's own sidecar
+  description: Sunny
+cat.gif:
+  title: A cat
+`,
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, name), []byte(content), 0644))
+	}
+
+	var logs bytes.Buffer
+	done := make(chan struct{})
+	defer close(done)
+	ts := httptest.NewServer(Index(log.New(&logs, "", 0), tempdir, done, nil))
+	defer ts.Close()
+
+	get := func() map[string]IndexMeta {
+		res, err := http.Get(ts.URL + "/?format=json")
+		require.NoError(t, err)
+		var data IndexData
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&data))
+		res.Body.Close()
+		described := make(map[string]IndexMeta)
+		for _, each := range data.Entries {
+			described[each.Name] = each.IndexMeta
+		}
+		return described
+	}
+
+	assert.Equal(t, map[string]IndexMeta{
+		"beach.jpg":   {Title: "At the beach", Description: "Sunny", Tags: []string{"sand", "sea"}},
+		"dog.png":     {Title: "Good dog", Alt: "a dog, sitting"},
+		"cat.gif":     {Title: "A cat"},
+		"broken.txt":  {},
+		"orphan.yaml": {},
+	}, get())
+	assert.Contains(t, logs.String(), "could not read metadata")
+
+	// changes to a sidecar are picked up
+	sidecar := filepath.Join(tempdir, "dog.png.json")
+	require.NoError(t, ioutil.WriteFile(sidecar, []byte(`{"title": "Very good dog"}`), 0644))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(sidecar, later, later))
+	assert.Equal(t, IndexMeta{Title: "Very good dog"}, get()["dog.png"])
+
+	res, err := http.Get(ts.URL + "/")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `<a href="/beach.jpg">At the beach</a> <small class="description">Sunny</small>`)
+
+	templ := template.Must(template.ParseFiles("testdata/sidecar.template"))
+	custom := httptest.NewServer(Index(log.New(ioutil.Discard, "", 0), tempdir, done, templ))
+	defer custom.Close()
+	res, err = http.Get(custom.URL + "/")
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `data-largesrc="/beach.jpg" data-title="At the beach" data-description="Sunny"`)
+	assert.Contains(t, string(body), `alt="Very good dog"`)
+}
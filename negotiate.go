@@ -0,0 +1,59 @@
+package dandler
+
+import (
+	"strconv"
+	"strings"
+)
+
+// acceptQuality returns the quality the given Accept style header assigns to
+// value. Exact matches win over wildcards, and values not matched at all get
+// 0. A missing header accepts everything equally.
+func acceptQuality(header, value string) float64 {
+	if strings.TrimSpace(header) == "" {
+		return 1
+	}
+	major := strings.SplitN(value, "/", 2)[0]
+
+	best, bestSpecificity := 0.0, -1
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		specificity := -1
+		switch {
+		case name == value:
+			specificity = 2
+		case name == major+"/*":
+			specificity = 1
+		case name == "*/*" || name == "*":
+			specificity = 0
+		}
+		if specificity <= bestSpecificity {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		best, bestSpecificity = q, specificity
+	}
+	return best
+}
+
+// wantsJSON reports if a request would rather have JSON than HTML - either by
+// asking for ?format=json, or preferring application/json in Accept.
+func wantsJSON(format, accept string) bool {
+	switch strings.ToLower(format) {
+	case "json":
+		return true
+	case "html":
+		return false
+	}
+	return acceptQuality(accept, "application/json") > acceptQuality(accept, "text/html")
+}
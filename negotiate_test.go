@@ -0,0 +1,46 @@
+package dandler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptQuality(t *testing.T) {
+	testData := []struct {
+		header string
+		value  string
+		q      float64
+	}{
+		{header: "", value: "application/json", q: 1},
+		{header: "application/json", value: "application/json", q: 1},
+		{header: "application/json", value: "text/html", q: 0},
+		{header: "text/*;q=0.5", value: "text/html", q: 0.5},
+		{header: "text/html;q=0.9, text/*;q=0.5", value: "text/html", q: 0.9},
+		{header: "*/*;q=0.1, text/html", value: "application/json", q: 0.1},
+		{header: "application/json; q=0", value: "application/json", q: 0},
+	}
+	for id, test := range testData {
+		assert.Equal(t, test.q, acceptQuality(test.header, test.value), "#%d - %q", id, test.header)
+	}
+}
+
+func TestWantsJSON(t *testing.T) {
+	testData := []struct {
+		format string
+		accept string
+		json   bool
+	}{
+		{json: false},
+		{format: "json", json: true},
+		{format: "JSON", accept: "text/html", json: true},
+		{format: "html", accept: "application/json", json: false},
+		{accept: "application/json", json: true},
+		{accept: "text/html,application/xhtml+xml,*/*;q=0.8", json: false},
+		{accept: "*/*", json: false},
+		{accept: "application/json, text/html;q=0.5", json: true},
+	}
+	for id, test := range testData {
+		assert.Equal(t, test.json, wantsJSON(test.format, test.accept), "#%d", id)
+	}
+}
@@ -0,0 +1,62 @@
+package dandler
+
+// Option changes the behavior of a handler. Options are shared between
+// handlers, so that the same setting can be applied consistently everywhere -
+// an Option that does not apply to a handler is ignored by it.
+type Option func(*options)
+
+// options holds every setting an Option can change.
+type options struct {
+	pool      *WorkPool
+	keepTags  map[ExifTag]bool
+	srcset    Srcset
+	pageSize  int
+	templates TemplateSource
+	events    string
+
+	archiveBytes int64
+	archiveFiles int
+	downloads    bool
+
+	ignore      []string
+	ignoreFile  string
+	symlinks    SymlinkPolicy
+	baseURL     string
+	indexCache  int
+	detectors   []ContentTypeDetector
+	directories DirectoryPolicy
+	indexFiles  []string
+
+	feedRecursive bool
+	feedMaxFiles  int
+
+	fallback        string
+	fallbackExclude []string
+	fallbackAssets  []string
+}
+
+func buildOptions(opts []Option) options {
+	o := options{
+		pool:     DefaultWorkPool,
+		keepTags: map[ExifTag]bool{TagOrientation: true},
+
+		archiveBytes: 1 << 30,
+		archiveFiles: 10000,
+		indexCache:   256,
+
+		indexFiles:     append([]string(nil), defaultIndexFiles...),
+		fallbackAssets: append([]string(nil), DefaultAssetExtensions...),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithWorkPool sets the pool used to limit image generation. Passing nil
+// removes all limits.
+func WithWorkPool(pool *WorkPool) Option {
+	return func(o *options) {
+		o.pool = pool
+	}
+}
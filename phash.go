@@ -0,0 +1,420 @@
+package dandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"math/bits"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nfnt/resize"
+)
+
+// HashKind selects the algorithm used to build a perceptual hash of an image.
+type HashKind int
+
+// These constants are to be used with ImageHash and Duplicates.
+const (
+	AverageHash    HashKind = iota // aHash - each pixel compared to the mean
+	DifferenceHash                 // dHash - each pixel compared to its neighbor
+	PerceptualHash                 // pHash - low frequencies of a DCT compared to the median
+)
+
+// ImageHash returns a 64 bit perceptual hash of the image. Similar images have
+// hashes with a small HammingDistance between them.
+func ImageHash(img image.Image, kind HashKind) uint64 {
+	switch kind {
+	case DifferenceHash:
+		return differenceHash(img)
+	case PerceptualHash:
+		return perceptualHash(img)
+	default:
+		return averageHash(img)
+	}
+}
+
+// HammingDistance returns the number of bits that differ between two hashes.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// grayscale shrinks the image to the given size and returns the luminance of
+// each pixel, row by row.
+func grayscale(img image.Image, width, height int) [][]float64 {
+	small := resize.Resize(uint(width), uint(height), img, resize.Bilinear)
+	bounds := small.Bounds()
+	out := make([][]float64, height)
+	for y := 0; y < height; y++ {
+		out[y] = make([]float64, width)
+		for x := 0; x < width; x++ {
+			gray := color.GrayModel.Convert(small.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray)
+			out[y][x] = float64(gray.Y)
+		}
+	}
+	return out
+}
+
+func averageHash(img image.Image) uint64 {
+	pixels := grayscale(img, 8, 8)
+	var total float64
+	for _, row := range pixels {
+		for _, p := range row {
+			total += p
+		}
+	}
+	mean := total / 64
+
+	var hash uint64
+	for _, row := range pixels {
+		for _, p := range row {
+			hash <<= 1
+			if p > mean {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func differenceHash(img image.Image) uint64 {
+	pixels := grayscale(img, 9, 8)
+	var hash uint64
+	for _, row := range pixels {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if row[x] > row[x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func perceptualHash(img image.Image) uint64 {
+	const size = 32
+	pixels := grayscale(img, size, size)
+
+	// only the top left 8x8 of the DCT is needed, so only that is computed
+	var coeffs [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					sum += pixels[y][x] *
+						math.Cos(float64(2*x+1)*float64(u)*math.Pi/(2*size)) *
+						math.Cos(float64(2*y+1)*float64(v)*math.Pi/(2*size))
+				}
+			}
+			coeffs[v*8+u] = sum
+		}
+	}
+
+	// the DC term is the overall brightness, and would skew the median
+	sorted := make([]float64, 63)
+	copy(sorted, coeffs[1:])
+	sort.Float64s(sorted)
+	median := (sorted[31] + sorted[32]) / 2
+
+	var hash uint64
+	for _, c := range coeffs {
+		hash <<= 1
+		if c > median {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+// Duplicates watches a directory - and sub directories - and keeps a
+// perceptual hash of every image within. Each request is answered with a JSON
+// list of clusters of images that are within threshold bits of each other.
+// The threshold can be changed per request with ?distance=N.
+//
+// Paths ignored with WithIgnore or WithIgnoreFile are left out, and symlinks
+// are only followed as WithSymlinks allows.
+//
+// The initial scan happens in the background - requests wait for it to finish.
+func Duplicates(logger *log.Logger, basepath string, done <-chan struct{},
+	kind HashKind, threshold int, opts ...Option) http.Handler {
+	idx, err := newHashIndex(logger, basepath, kind, buildOptions(opts))
+	if err != nil {
+		logger.Printf("failed to index directory [%s] - %v", basepath, err)
+		return ResponseCode(500, "failed to initialize Duplicates - %v", err)
+	}
+
+	w, err := watchChanges(basepath, done)
+	if err != nil {
+		logger.Printf("failed to watch directory [%s] - %v", basepath, err)
+		return ResponseCode(500, "failed to initialize Duplicates - %v", err)
+	}
+	w.subscribeQueued(idx.update)
+
+	go idx.scan()
+
+	return duplicatesHandler{idx: idx, threshold: threshold, l: logger}
+}
+
+// DuplicateData is the response sent by a Duplicates handler.
+type DuplicateData struct {
+	Distance int        `json:"distance"`
+	Clusters [][]string `json:"clusters"`
+}
+
+type duplicatesHandler struct {
+	idx       *hashIndex
+	threshold int
+	l         *log.Logger
+}
+
+func (h duplicatesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	distance := h.threshold
+	if raw := r.URL.Query().Get("distance"); raw != "" {
+		var err error
+		distance, err = strconv.Atoi(raw)
+		if err != nil || distance < 0 || distance > 64 {
+			http.Error(w, fmt.Sprintf("bad distance: %s", raw), http.StatusBadRequest)
+			h.l.Printf("400 - bad distance requested: %s", raw)
+			return
+		}
+	}
+
+	select {
+	case <-h.idx.ready:
+	case <-r.Context().Done():
+		return
+	}
+
+	data := DuplicateData{Distance: distance, Clusters: h.idx.clusters(distance)}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		h.l.Printf("500 - error responding: %s", err)
+	}
+}
+
+// hashIndex holds the hash of every image below a location, keyed by the
+// path relative to that location.
+type hashIndex struct {
+	basePath string
+	kind     HashKind
+	l        *log.Logger
+	ignore   *ignorer
+	symlinks SymlinkPolicy
+	ready    chan struct{}
+
+	lock       sync.RWMutex
+	hashes     map[string]uint64
+	generation int
+
+	// clusters are kept for each distance asked for, until the hashes change -
+	// and only built by one request at a time
+	clusterLock sync.Mutex
+	clustered   map[int][][]string
+	clusteredAt int
+}
+
+func newHashIndex(logger *log.Logger, basepath string, kind HashKind, o options) (*hashIndex, error) {
+	stat, err := os.Stat(basepath)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		return nil, fmt.Errorf("not a directory: %s", basepath)
+	}
+	return &hashIndex{
+		basePath: basepath,
+		kind:     kind,
+		l:        logger,
+		ignore:   newListIgnorer(http.Dir(basepath), o),
+		symlinks: o.symlinks,
+		ready:    make(chan struct{}),
+		hashes:   make(map[string]uint64),
+	}, nil
+}
+
+// scan hashes everything below the base path, then marks the index ready.
+func (idx *hashIndex) scan() {
+	defer close(idx.ready)
+	idx.walk("/")
+}
+
+// walk hashes every image from the directory start down.
+func (idx *hashIndex) walk(start string) {
+	location, stat, err := idx.symlinks.resolve(idx.basePath, start)
+	if err != nil || !stat.IsDir() || idx.ignore.ignored(start, true) {
+		return
+	}
+	idx.walkDir(location, start, []os.FileInfo{stat})
+}
+
+// walkDir hashes every image in the directory at location, and below it -
+// never following symlinks back into a directory already being looked through.
+func (idx *hashIndex) walkDir(location, dirPath string, ancestors []os.FileInfo) {
+	f, err := os.Open(location)
+	if err != nil {
+		return
+	}
+	contents, err := f.Readdir(0)
+	f.Close()
+	if err != nil {
+		return
+	}
+
+	scope := idx.ignore.in(dirPath)
+	for _, each := range contents {
+		entryPath := path.Join(dirPath, each.Name())
+		entryLocation, info, ok := idx.symlinks.resolveEntry(idx.basePath, dirPath, each)
+		if !ok || scope.ignored(each.Name(), info.IsDir()) {
+			continue
+		}
+		if !info.IsDir() {
+			idx.add(entryPath)
+			continue
+		}
+		stat, err := os.Stat(entryLocation)
+		if err != nil || isLoop(ancestors, stat) {
+			continue
+		}
+		idx.walkDir(entryLocation, entryPath, append(ancestors[:len(ancestors):len(ancestors)], stat))
+	}
+}
+
+// add hashes the image at the given relative path - anything that is not an
+// image is skipped.
+func (idx *hashIndex) add(name string) {
+	f, err := idx.symlinks.open(idx.basePath, name)
+	if err != nil {
+		idx.remove(name)
+		return
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		idx.remove(name)
+		return
+	}
+	hash := ImageHash(img, idx.kind)
+
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	if old, ok := idx.hashes[name]; !ok || old != hash {
+		idx.hashes[name] = hash
+		idx.generation++
+	}
+}
+
+// remove drops the given path - and anything below it - from the index.
+func (idx *hashIndex) remove(name string) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	before := len(idx.hashes)
+	delete(idx.hashes, name)
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	for each := range idx.hashes {
+		if strings.HasPrefix(each, prefix) {
+			delete(idx.hashes, each)
+		}
+	}
+	if len(idx.hashes) != before {
+		idx.generation++
+	}
+}
+
+// update brings the index up to date with a change. It reads the files that
+// changed, so it runs from a queue rather than the watcher itself.
+func (idx *hashIndex) update(c change) {
+	_, stat, err := idx.symlinks.resolve(idx.basePath, c.Path)
+	switch {
+	case err != nil || idx.ignore.ignored(c.Path, stat.IsDir()):
+		idx.remove(c.Path)
+	case stat.IsDir():
+		if c.Op == changeAdd || c.Op == changeRename {
+			idx.walk(c.Path)
+		}
+	default:
+		idx.add(c.Path)
+	}
+}
+
+// clusters groups every image with all images within distance of it. Only
+// groups with more than one image are returned. Comparing every image with
+// every other is costly, so the clusters for each distance are kept until an
+// image is added or removed - they must not be changed by the caller.
+func (idx *hashIndex) clusters(distance int) [][]string {
+	idx.clusterLock.Lock()
+	defer idx.clusterLock.Unlock()
+
+	idx.lock.RLock()
+	generation := idx.generation
+	idx.lock.RUnlock()
+	if idx.clustered == nil || idx.clusteredAt != generation {
+		idx.clustered = make(map[int][][]string)
+		idx.clusteredAt = generation
+	}
+	if clusters, ok := idx.clustered[distance]; ok {
+		return clusters
+	}
+	clusters := idx.buildClusters(distance)
+	idx.clustered[distance] = clusters
+	return clusters
+}
+
+// buildClusters compares every image with every other, to find clusters.
+func (idx *hashIndex) buildClusters(distance int) [][]string {
+	idx.lock.RLock()
+	names := make([]string, 0, len(idx.hashes))
+	for name := range idx.hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hashes := make([]uint64, len(names))
+	for i, name := range names {
+		hashes[i] = idx.hashes[name]
+	}
+	idx.lock.RUnlock()
+
+	// union-find, so that chains of near matches end up in one cluster
+	parent := make([]int, len(names))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			if HammingDistance(hashes[i], hashes[j]) <= distance {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	groups := make(map[int][]string)
+	for i, name := range names {
+		root := find(i)
+		groups[root] = append(groups[root], name)
+	}
+
+	clusters := [][]string{}
+	for _, group := range groups {
+		if len(group) > 1 {
+			clusters = append(clusters, group)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i][0] < clusters[j][0] })
+	return clusters
+}
@@ -0,0 +1,217 @@
+package dandler
+
+import (
+	"encoding/json"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nfnt/resize"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHammingDistance(t *testing.T) {
+	assert.Equal(t, 0, HammingDistance(0xff, 0xff))
+	assert.Equal(t, 8, HammingDistance(0xff, 0x00))
+	assert.Equal(t, 64, HammingDistance(0, ^uint64(0)))
+}
+
+func TestImageHash(t *testing.T) {
+	original := decodeTestImage(t, "testdata/lemur_pudding_cups.jpg")
+	shrunk := resize.Resize(200, 0, original, resize.Bilinear)
+	other := decodeTestImage(t, "testdata/spooning_a_barret.png")
+
+	for _, kind := range []HashKind{AverageHash, DifferenceHash, PerceptualHash} {
+		assert.Equal(t, ImageHash(original, kind), ImageHash(original, kind),
+			"kind %d - hash is not stable", kind)
+		assert.True(t, HammingDistance(ImageHash(original, kind), ImageHash(shrunk, kind)) <= 6,
+			"kind %d - resized image is not similar", kind)
+		assert.True(t, HammingDistance(ImageHash(original, kind), ImageHash(other, kind)) > 10,
+			"kind %d - different images are too similar", kind)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+
+	original := decodeTestImage(t, "testdata/lemur_pudding_cups.jpg")
+	writeTestPNG(t, filepath.Join(tempdir, "lemur.png"), original)
+	require.NoError(t, os.Mkdir(filepath.Join(tempdir, "small"), 0755))
+	writeTestPNG(t, filepath.Join(tempdir, "small", "lemur.png"),
+		resize.Resize(200, 0, original, resize.Bilinear))
+	writeTestPNG(t, filepath.Join(tempdir, "barret.png"),
+		decodeTestImage(t, "testdata/spooning_a_barret.png"))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "notes.txt"), []byte("not an image"), 0644))
+
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Duplicates(logger, tempdir, done, PerceptualHash, 6))
+	defer ts.Close()
+
+	data := getDuplicates(t, ts.URL)
+	assert.Equal(t, 6, data.Distance)
+	assert.Equal(t, [][]string{{"/lemur.png", "/small/lemur.png"}}, data.Clusters)
+
+	data = getDuplicates(t, ts.URL+"?distance=64")
+	assert.Equal(t, 64, data.Distance)
+	assert.Equal(t, [][]string{{"/barret.png", "/lemur.png", "/small/lemur.png"}}, data.Clusters)
+
+	res, err := http.Get(ts.URL + "?distance=nope")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// a new copy should show up once the watcher picks it up
+	writeTestPNG(t, filepath.Join(tempdir, "copy.png"), original)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		data = getDuplicates(t, ts.URL)
+		if len(data.Clusters) == 1 && len(data.Clusters[0]) == 3 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Equal(t, [][]string{{"/copy.png", "/lemur.png", "/small/lemur.png"}}, data.Clusters)
+}
+
+func TestDuplicates_ignore(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	outside, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(outside)
+
+	original := decodeTestImage(t, "testdata/lemur_pudding_cups.jpg")
+	for _, name := range []string{"lemur.png", "skip/lemur.png", ".hidden/lemur.png", "inside/lemur.png"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(tempdir, name)), 0755))
+		writeTestPNG(t, filepath.Join(tempdir, filepath.FromSlash(name)), original)
+	}
+	writeTestPNG(t, filepath.Join(outside, "lemur.png"), original)
+	require.NoError(t, os.Symlink(outside, filepath.Join(tempdir, "outside")))
+	require.NoError(t, os.Symlink(filepath.Join(tempdir, "inside"), filepath.Join(tempdir, "linked")))
+
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Duplicates(logger, tempdir, done, PerceptualHash, 6, WithIgnore("skip/"),
+		WithSymlinks(SymlinksWithinRoot)))
+	defer ts.Close()
+
+	data := getDuplicates(t, ts.URL)
+	assert.Equal(t, [][]string{{"/inside/lemur.png", "/lemur.png", "/linked/lemur.png"}}, data.Clusters)
+}
+
+func TestChangeQueue(t *testing.T) {
+	quit := make(chan struct{})
+	defer close(quit)
+	handled := make(chan change, 10)
+	release := make(chan struct{})
+	q := &changeQueue{pending: make(map[string]change), wake: make(chan struct{}, 1)}
+	go q.run(quit, func(c change) {
+		<-release
+		handled <- c
+	})
+
+	// the first change holds up the worker - the rest queue without blocking
+	q.add(change{Op: changeAdd, Path: "/first"})
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 1000; i++ {
+		q.add(change{Op: changeWrite, Path: "/busy"})
+	}
+	q.add(change{Op: changeRename, Path: "/dir"})
+	q.add(change{Op: changeWrite, Path: "/dir"})
+	close(release)
+
+	var got []change
+	for len(got) < 3 {
+		select {
+		case c := <-handled:
+			got = append(got, c)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only handled %v", got)
+		}
+	}
+	assert.Equal(t, []change{
+		{Op: changeAdd, Path: "/first"},
+		{Op: changeWrite, Path: "/busy"},
+		{Op: changeRename, Path: "/dir"},
+	}, got)
+	select {
+	case c := <-handled:
+		t.Errorf("handled more than queued: %v", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDuplicates_badpath(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Duplicates(logger, "not-a-folder", done, AverageHash, 4))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 500, res.StatusCode)
+}
+
+func getDuplicates(t *testing.T, url string) DuplicateData {
+	res, err := http.Get(url)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/json; charset=utf-8", res.Header.Get("Content-Type"))
+
+	var data DuplicateData
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&data))
+	return data
+}
+
+func decodeTestImage(t *testing.T, name string) image.Image {
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	require.NoError(t, err)
+	return img
+}
+
+func writeTestPNG(t *testing.T, name string, img image.Image) {
+	f, err := os.Create(name)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, png.Encode(f, img))
+}
+
+func TestHashIndex_clusterCache(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	idx, err := newHashIndex(log.New(ioutil.Discard, "", 0), tempdir, PerceptualHash, buildOptions(nil))
+	require.NoError(t, err)
+	idx.hashes = map[string]uint64{"/a": 0, "/b": 1}
+
+	assert.Equal(t, [][]string{{"/a", "/b"}}, idx.clusters(1))
+
+	// kept until the index changes through add or remove
+	idx.hashes["/c"] = 1
+	assert.Equal(t, [][]string{{"/a", "/b"}}, idx.clusters(1))
+	assert.Equal(t, [][]string{{"/b", "/c"}}, idx.clusters(0), "each distance is kept apart")
+
+	idx.remove("/b")
+	assert.Equal(t, [][]string{{"/a", "/c"}}, idx.clusters(1))
+	assert.Equal(t, [][]string{}, idx.clusters(0))
+}
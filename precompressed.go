@@ -0,0 +1,59 @@
+package dandler
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// precompressedVariants are the files ContentType looks for beside the one
+// requested, most preferred first.
+var precompressedVariants = []struct {
+	ext      string
+	encoding string
+}{
+	{ext: ".br", encoding: "br"},
+	{ext: ".zst", encoding: "zstd"},
+	{ext: ".gz", encoding: "gzip"},
+}
+
+// precompressed finds the variant of the file at urlPath that suits the
+// request's Accept-Encoding best - the highest quality, then the order of
+// precompressedVariants. It also reports if there were any variants at all,
+// in which case the response depends on Accept-Encoding. Variants are found
+// with the same ignore rules and symlink policy as the file itself.
+func (c contentTypeHandler) precompressed(urlPath string, r *http.Request) (*os.File, os.FileInfo, string, bool) {
+	accept := r.Header.Get("Accept-Encoding")
+
+	var best *os.File
+	var bestStat os.FileInfo
+	var bestEncoding string
+	bestQ, found := 0.0, false
+	for _, variant := range precompressedVariants {
+		name := urlPath + variant.ext
+		_, stat, err := c.symlinks.resolve(c.basePath, name)
+		if err != nil || !stat.Mode().IsRegular() || c.ignore.ignored(name, false) {
+			continue
+		}
+		found = true
+
+		// a missing Accept-Encoding would allow anything, but is much more
+		// likely to mean the client does not decompress at all
+		if strings.TrimSpace(accept) == "" {
+			continue
+		}
+		q := acceptQuality(accept, variant.encoding)
+		if q <= bestQ {
+			continue
+		}
+		f, err := c.symlinks.open(c.basePath, name)
+		if err != nil {
+			continue
+		}
+		if best != nil {
+			best.Close()
+		}
+		best, bestStat, bestEncoding, bestQ = f, stat, variant.encoding, q
+	}
+	return best, bestStat, bestEncoding, found
+}
@@ -0,0 +1,94 @@
+package dandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentType_precompressed(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+
+	original := bytes.Repeat([]byte("console.log('ohai');\n"), 40)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, err = zw.Write(original)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	for name, content := range map[string][]byte{
+		"app.js":     original,
+		"app.js.gz":  gz.Bytes(),
+		"app.js.br":  []byte("brotli"),
+		"app.js.zst": []byte("zstd"),
+		"plain.txt":  original,
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, name), content, 0644))
+	}
+
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(ContentType(logger, tempdir))
+	defer ts.Close()
+
+	var testData = []struct {
+		uri      string
+		accept   string
+		encoding string
+		body     []byte
+	}{
+		{uri: "/app.js", accept: "", encoding: "", body: original},
+		{uri: "/app.js", accept: "identity", encoding: "", body: original},
+		{uri: "/app.js", accept: "gzip", encoding: "gzip", body: gz.Bytes()},
+		{uri: "/app.js", accept: "gzip, deflate, br", encoding: "br", body: []byte("brotli")},
+		{uri: "/app.js", accept: "br;q=0.5, gzip", encoding: "gzip", body: gz.Bytes()},
+		{uri: "/app.js", accept: "zstd, gzip;q=0", encoding: "zstd", body: []byte("zstd")},
+		{uri: "/app.js", accept: "*", encoding: "br", body: []byte("brotli")},
+		{uri: "/plain.txt", accept: "gzip, br", encoding: "", body: original},
+	}
+	for _, test := range testData {
+		req, err := http.NewRequest("GET", ts.URL+test.uri, nil)
+		require.NoError(t, err)
+		if test.accept != "" {
+			req.Header.Set("Accept-Encoding", test.accept)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, res.StatusCode, test.accept)
+		assert.Equal(t, test.encoding, res.Header.Get("Content-Encoding"), test.accept)
+		assert.Equal(t, "text/plain; charset=utf-8", res.Header.Get("Content-Type"), test.accept)
+		assert.Equal(t, test.body, body, test.accept)
+		if test.uri == "/app.js" {
+			assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"), test.accept)
+		} else {
+			assert.Empty(t, res.Header.Get("Vary"), test.accept)
+		}
+	}
+
+	// ranges are of the variant sent
+	req, err := http.NewRequest("GET", ts.URL+"/app.js", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=0-1")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, gz.Bytes()[:2], body)
+}
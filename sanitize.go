@@ -0,0 +1,387 @@
+package dandler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// ExifTag identifies a tag within the main image directory of EXIF metadata.
+type ExifTag uint16
+
+// These are the tags that may be kept by Sanitize with WithExifTags. Tags that
+// point to other directories - such as the GPS or EXIF directories - are never
+// kept.
+const (
+	TagImageDescription ExifTag = 0x010e
+	TagMake             ExifTag = 0x010f
+	TagModel            ExifTag = 0x0110
+	TagOrientation      ExifTag = 0x0112
+	TagSoftware         ExifTag = 0x0131
+	TagDateTime         ExifTag = 0x0132
+	TagArtist           ExifTag = 0x013b
+	TagCopyright        ExifTag = 0x8298
+)
+
+// pngTextKeys maps tags to the matching PNG text chunk keywords.
+var pngTextKeys = map[ExifTag]string{
+	TagImageDescription: "Description",
+	TagSoftware:         "Software",
+	TagArtist:           "Author",
+	TagCopyright:        "Copyright",
+	TagDateTime:         "Creation Time",
+}
+
+// WithExifTags sets the metadata tags Sanitize keeps. Without this option, only
+// TagOrientation is kept, so that images still display the right way up.
+func WithExifTags(keep ...ExifTag) Option {
+	return func(o *options) {
+		o.keepTags = make(map[ExifTag]bool)
+		for _, tag := range keep {
+			o.keepTags[tag] = true
+		}
+	}
+}
+
+// Sanitize serves files like ContentType, but JPEG and PNG images have their
+// metadata - camera details, GPS position, comments and the like - removed as
+// they are served. Pixel data is passed through untouched. The tags to keep
+// are set with WithExifTags. Paths ignored with WithIgnore or WithIgnoreFile
+// are not served, symlinks are only followed as WithSymlinks allows, and
+// directories are refused.
+func Sanitize(logger *log.Logger, basePath string, opts ...Option) http.Handler {
+	o := buildOptions(opts)
+	return sanitizeHandler{basePath: basePath, keep: o.keepTags, l: logger,
+		ignore: newIgnorer(http.Dir(basePath), o), symlinks: o.symlinks}
+}
+
+type sanitizeHandler struct {
+	basePath string
+	keep     map[ExifTag]bool
+	l        *log.Logger
+	ignore   *ignorer
+	symlinks SymlinkPolicy
+}
+
+func (h sanitizeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	urlPath := path.Clean("/" + r.URL.Path)
+	f, stat, err := openAllowedFile(h.basePath, urlPath, h.symlinks, h.ignore)
+	if err != nil {
+		failOpen(w, r, h.l, h.basePath, urlPath, err)
+		return
+	}
+	defer f.Close()
+
+	raw, err := ioutil.ReadAll(f)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot read file: %s", r.URL.Path), http.StatusInternalServerError)
+		h.l.Printf("500 - could not read from file: %s - %s", filepath.Join(h.basePath, r.URL.Path), err)
+		return
+	}
+
+	contentType := http.DetectContentType(raw)
+	out := raw
+	switch contentType {
+	case "image/jpeg":
+		out, err = stripJPEG(raw, h.keep)
+	case "image/png":
+		out, err = stripPNG(raw, h.keep)
+	}
+	if err != nil {
+		// never fall back to the original - it may be exactly what is to be hidden
+		http.Error(w, fmt.Sprintf("cannot read file: %s", r.URL.Path), http.StatusInternalServerError)
+		h.l.Printf("500 - could not sanitize file: %s - %s", filepath.Join(h.basePath, r.URL.Path), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, r.URL.Path, stat.ModTime(), bytes.NewReader(out))
+}
+
+var errMalformed = errors.New("malformed image")
+
+// stripJPEG removes metadata segments from a JPEG. Segments that change how
+// the image is displayed - JFIF, ICC profiles and Adobe color transforms - are
+// kept. Segments between the scans of a progressive image are filtered the
+// same way, and anything after the end of the image - such as the secondary
+// images of an MPF file, or the video of a motion photo - is dropped.
+func stripJPEG(data []byte, keep map[ExifTag]bool) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	pos := 2
+	for pos < len(data) {
+		if data[pos] != 0xff {
+			return nil, errMalformed
+		}
+		// any number of 0xff may pad before a marker
+		for pos < len(data) && data[pos] == 0xff {
+			pos++
+		}
+		if pos >= len(data) {
+			return nil, errMalformed
+		}
+		marker := data[pos]
+		pos++
+
+		// markers without a length
+		if marker == 0xd8 || marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			out.Write([]byte{0xff, marker})
+			continue
+		}
+		if marker == 0xd9 {
+			out.Write([]byte{0xff, marker})
+			break
+		}
+
+		if pos+2 > len(data) {
+			return nil, errMalformed
+		}
+		length := int(binary.BigEndian.Uint16(data[pos:]))
+		if length < 2 || pos+length > len(data) {
+			return nil, errMalformed
+		}
+		segment := data[pos+2 : pos+length]
+		whole := data[pos-2 : pos+length]
+		pos += length
+
+		switch {
+		case marker == 0xda:
+			// start of scan - image data follows, up to the next marker
+			out.Write(whole)
+			end := scanEnd(data, pos)
+			out.Write(data[pos:end])
+			pos = end
+		case marker == 0xe0 && bytes.HasPrefix(segment, []byte("JFIF\x00")):
+			out.Write(whole)
+		case marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")):
+			tiff := filterTIFF(segment[6:], keep)
+			if tiff == nil {
+				continue
+			}
+			payload := append([]byte("Exif\x00\x00"), tiff...)
+			if len(payload)+2 > 0xffff {
+				continue
+			}
+			out.Write([]byte{0xff, 0xe1})
+			binary.Write(out, binary.BigEndian, uint16(len(payload)+2))
+			out.Write(payload)
+		case marker == 0xe2 && bytes.HasPrefix(segment, []byte("ICC_PROFILE\x00")):
+			out.Write(whole)
+		case marker == 0xee && bytes.HasPrefix(segment, []byte("Adobe")):
+			out.Write(whole)
+		case marker >= 0xe0 && marker <= 0xef, marker == 0xfe:
+			// other application segments and comments are dropped
+		default:
+			out.Write(whole)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// scanEnd returns where the image data starting at pos ends - at the first
+// marker that is not a restart marker, or an 0xff stuffed within the data.
+func scanEnd(data []byte, pos int) int {
+	for pos+1 < len(data) {
+		if data[pos] != 0xff {
+			pos++
+			continue
+		}
+		next := data[pos+1]
+		switch {
+		case next == 0x00, next >= 0xd0 && next <= 0xd7:
+			pos += 2
+		case next == 0xff:
+			// padding before a marker
+			pos++
+		default:
+			return pos
+		}
+	}
+	return len(data)
+}
+
+// pngKeepChunks are the ancillary chunks that affect how an image is shown.
+var pngKeepChunks = map[string]bool{
+	"tRNS": true, "gAMA": true, "cHRM": true, "sRGB": true, "iCCP": true,
+	"sBIT": true, "pHYs": true, "bKGD": true, "hIST": true, "sPLT": true,
+	"acTL": true, "fcTL": true, "fdAT": true,
+}
+
+// stripPNG removes metadata chunks from a PNG. Critical chunks, and ancillary
+// chunks that change how the image is displayed, are kept.
+func stripPNG(data []byte, keep map[ExifTag]bool) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, errMalformed
+	}
+
+	keepText := make(map[string]bool)
+	for tag, key := range pngTextKeys {
+		if keep[tag] {
+			keepText[key] = true
+		}
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.WriteString(signature)
+	pos := len(signature)
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, errMalformed
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		kind := string(data[pos+4 : pos+8])
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errMalformed
+		}
+		body := data[pos+8 : pos+8+length]
+		whole := data[pos:end]
+		pos = end
+
+		switch {
+		case kind[0] >= 'A' && kind[0] <= 'Z':
+			// critical chunks are needed to decode the image
+			out.Write(whole)
+		case pngKeepChunks[kind]:
+			out.Write(whole)
+		case kind == "eXIf":
+			if tiff := filterTIFF(body, keep); tiff != nil {
+				writePNGChunk(out, kind, tiff)
+			}
+		case kind == "tEXt" || kind == "zTXt" || kind == "iTXt":
+			if keyword := bytes.SplitN(body, []byte{0}, 2)[0]; keepText[string(keyword)] {
+				out.Write(whole)
+			}
+		}
+
+		if kind == "IEND" {
+			break
+		}
+	}
+	return out.Bytes(), nil
+}
+
+func writePNGChunk(out *bytes.Buffer, kind string, body []byte) {
+	binary.Write(out, binary.BigEndian, uint32(len(body)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(body)
+	out.WriteString(kind)
+	out.Write(body)
+	binary.Write(out, binary.BigEndian, crc.Sum32())
+}
+
+// tiffTypeSizes is the size in bytes of each TIFF field type.
+var tiffTypeSizes = map[uint16]int{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// tiffPointerTags point to other directories, and are never kept.
+var tiffPointerTags = map[uint16]bool{
+	0x8769: true, // EXIF
+	0x8825: true, // GPS
+	0xa005: true, // interoperability
+	0x014a: true, // sub IFDs
+}
+
+type tiffEntry struct {
+	tag   uint16
+	kind  uint16
+	count uint32
+	value []byte
+}
+
+// filterTIFF rebuilds the EXIF data as a single directory holding only the
+// kept tags. nil is returned if there is nothing to keep, or the data cannot
+// be understood.
+func filterTIFF(data []byte, keep map[ExifTag]bool) []byte {
+	if len(keep) == 0 || len(data) < 8 {
+		return nil
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+	if order.Uint16(data[2:]) != 42 {
+		return nil
+	}
+
+	ifd := int(order.Uint32(data[4:]))
+	if ifd < 8 || ifd+2 > len(data) {
+		return nil
+	}
+	count := int(order.Uint16(data[ifd:]))
+	if ifd+2+count*12 > len(data) {
+		return nil
+	}
+
+	var entries []tiffEntry
+	for i := 0; i < count; i++ {
+		raw := data[ifd+2+i*12:]
+		e := tiffEntry{tag: order.Uint16(raw), kind: order.Uint16(raw[2:]), count: order.Uint32(raw[4:])}
+		size, ok := tiffTypeSizes[e.kind]
+		if !ok || !keep[ExifTag(e.tag)] || tiffPointerTags[e.tag] {
+			continue
+		}
+		total := size * int(e.count)
+		if total < 0 || e.count > uint32(len(data)) {
+			continue
+		}
+		if total <= 4 {
+			e.value = raw[8:12]
+		} else {
+			offset := int(order.Uint32(raw[8:]))
+			if offset < 0 || offset+total > len(data) {
+				continue
+			}
+			e.value = data[offset : offset+total]
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	// header, then the directory, then any values too large to fit inline
+	head := make([]byte, 8+2+len(entries)*12+4)
+	copy(head, data[:4])
+	order.PutUint32(head[4:], 8)
+	order.PutUint16(head[8:], uint16(len(entries)))
+	var extra []byte
+	for i, e := range entries {
+		raw := head[10+i*12:]
+		order.PutUint16(raw, e.tag)
+		order.PutUint16(raw[2:], e.kind)
+		order.PutUint32(raw[4:], e.count)
+		if tiffTypeSizes[e.kind]*int(e.count) <= 4 {
+			copy(raw[8:12], e.value)
+			continue
+		}
+		if len(extra)%2 == 1 {
+			// values start on a word boundary
+			extra = append(extra, 0)
+		}
+		order.PutUint32(raw[8:], uint32(len(head)+len(extra)))
+		extra = append(extra, e.value...)
+	}
+	return append(head, extra...)
+}
@@ -0,0 +1,222 @@
+package dandler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testEXIF builds little endian EXIF data with a camera make, an orientation,
+// a copyright and a pointer to GPS data.
+func testEXIF() []byte {
+	le := binary.LittleEndian
+	buf := []byte("II\x2a\x00\x08\x00\x00\x00")
+
+	entry := func(tag, kind uint16, count, value uint32) {
+		raw := make([]byte, 12)
+		le.PutUint16(raw, tag)
+		le.PutUint16(raw[2:], kind)
+		le.PutUint32(raw[4:], count)
+		le.PutUint32(raw[8:], value)
+		buf = append(buf, raw...)
+	}
+
+	// directory at 8, 4 entries, so values start at 8+2+48+4 = 62
+	buf = append(buf, 4, 0)
+	entry(uint16(TagMake), 2, 12, 62)
+	entry(uint16(TagOrientation), 3, 1, 6)
+	entry(uint16(TagCopyright), 2, 4, le.Uint32([]byte("jak\x00")))
+	entry(0x8825, 4, 1, 74)
+	buf = append(buf, 0, 0, 0, 0)
+	buf = append(buf, []byte("SecretCam 9\x00")...)
+	// GPS directory at 74, with just a latitude reference
+	buf = append(buf, 1, 0)
+	entry(0x0001, 2, 2, le.Uint32([]byte("N\x00\x00\x00")))
+	buf = append(buf, 0, 0, 0, 0)
+	return buf
+}
+
+func testJPEG(t *testing.T) []byte {
+	var plain bytes.Buffer
+	require.NoError(t, jpeg.Encode(&plain, image.NewGray(image.Rect(0, 0, 16, 16)), nil))
+
+	segment := func(marker byte, body []byte) []byte {
+		out := []byte{0xff, marker, 0, 0}
+		binary.BigEndian.PutUint16(out[2:], uint16(len(body)+2))
+		return append(out, body...)
+	}
+
+	var withMeta bytes.Buffer
+	withMeta.Write(plain.Bytes()[:2])
+	withMeta.Write(segment(0xe1, append([]byte("Exif\x00\x00"), testEXIF()...)))
+	withMeta.Write(segment(0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00<secret/>")))
+	withMeta.Write(segment(0xfe, []byte("secret comment")))
+	withMeta.Write(plain.Bytes()[2:])
+	return withMeta.Bytes()
+}
+
+func testPNG(t *testing.T) []byte {
+	var plain bytes.Buffer
+	require.NoError(t, png.Encode(&plain, image.NewGray(image.Rect(0, 0, 16, 16))))
+
+	// metadata goes right after the IHDR chunk
+	ihdrEnd := 8 + 12 + 13
+	var withMeta bytes.Buffer
+	withMeta.Write(plain.Bytes()[:ihdrEnd])
+	writePNGChunk(&withMeta, "eXIf", testEXIF())
+	writePNGChunk(&withMeta, "tEXt", []byte("Comment\x00secret comment"))
+	writePNGChunk(&withMeta, "tEXt", []byte("Copyright\x00jak"))
+	writePNGChunk(&withMeta, "tIME", []byte{0x07, 0xe1, 1, 2, 3, 4, 5})
+	withMeta.Write(plain.Bytes()[ihdrEnd:])
+	return withMeta.Bytes()
+}
+
+func TestFilterTIFF(t *testing.T) {
+	exif := testEXIF()
+	assert.Nil(t, filterTIFF(exif, nil))
+	assert.Nil(t, filterTIFF(exif, map[ExifTag]bool{TagArtist: true}))
+	assert.Nil(t, filterTIFF([]byte("garbage data"), map[ExifTag]bool{TagOrientation: true}))
+
+	// the GPS pointer can never be kept, even when asked for
+	out := filterTIFF(exif, map[ExifTag]bool{TagOrientation: true, TagMake: true, 0x8825: true})
+	require.NotNil(t, out)
+	le := binary.LittleEndian
+	assert.Equal(t, uint16(2), le.Uint16(out[8:]))
+	assert.Equal(t, uint16(TagMake), le.Uint16(out[10:]))
+	makeOffset := le.Uint32(out[18:])
+	assert.Equal(t, "SecretCam 9\x00", string(out[makeOffset:makeOffset+12]))
+	assert.Equal(t, uint16(TagOrientation), le.Uint16(out[22:]))
+	assert.Equal(t, uint16(6), le.Uint16(out[30:]))
+	assert.NotContains(t, string(out), "N\x00")
+}
+
+func TestStripJPEG(t *testing.T) {
+	raw := testJPEG(t)
+
+	out, err := stripJPEG(raw, map[ExifTag]bool{TagOrientation: true, TagCopyright: true})
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "SecretCam")
+	assert.NotContains(t, string(out), "secret")
+	assert.Contains(t, string(out), "Exif\x00\x00")
+	assert.Contains(t, string(out), "jak\x00")
+
+	// image data is passed through byte for byte
+	sos := bytes.Index(raw, []byte{0xff, 0xda})
+	assert.True(t, bytes.HasSuffix(out, raw[sos:]))
+	_, err = jpeg.Decode(bytes.NewReader(out))
+	assert.NoError(t, err)
+
+	out, err = stripJPEG(raw, nil)
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "Exif")
+
+	_, err = stripJPEG([]byte("not a jpeg"), nil)
+	assert.Error(t, err)
+}
+
+func TestStripJPEG_afterScan(t *testing.T) {
+	raw := testJPEG(t)
+	eoi := bytes.LastIndex(raw, []byte{0xff, 0xd9})
+	require.True(t, eoi > 0)
+
+	// a segment after the image data, as between progressive scans, and a
+	// secondary image with its own metadata after the end of the image
+	var doctored bytes.Buffer
+	doctored.Write(raw[:eoi])
+	doctored.Write([]byte{0xff, 0xe1, 0x00, 0x11})
+	doctored.WriteString("Exif\x00\x00SecretGPS")
+	doctored.Write([]byte{0xff, 0xfe, 0x00, 0x0d})
+	doctored.WriteString("secret note")
+	doctored.Write(raw[eoi:])
+	doctored.Write(raw)
+	doctored.WriteString("ftypmp42 secret video")
+
+	out, err := stripJPEG(doctored.Bytes(), nil)
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "Secret")
+	assert.NotContains(t, string(out), "secret")
+	assert.True(t, bytes.HasSuffix(out, []byte{0xff, 0xd9}), "nothing is kept after the end of the image")
+	_, err = jpeg.Decode(bytes.NewReader(out))
+	assert.NoError(t, err)
+
+	// stuffed bytes and restart markers within the data do not end it
+	data := []byte{0x12, 0xff, 0x00, 0x34, 0xff, 0xd3, 0x56, 0xff, 0xff, 0xd9}
+	assert.Equal(t, 8, scanEnd(data, 0))
+	assert.Equal(t, 3, scanEnd([]byte{1, 2, 3}, 0))
+}
+
+func TestStripPNG(t *testing.T) {
+	raw := testPNG(t)
+
+	out, err := stripPNG(raw, map[ExifTag]bool{TagOrientation: true, TagCopyright: true})
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "SecretCam")
+	assert.NotContains(t, string(out), "secret")
+	assert.NotContains(t, string(out), "tIME")
+	assert.Contains(t, string(out), "eXIf")
+	assert.Contains(t, string(out), "Copyright\x00jak")
+	_, err = png.Decode(bytes.NewReader(out))
+	assert.NoError(t, err)
+
+	out, err = stripPNG(raw, nil)
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "eXIf")
+	assert.NotContains(t, string(out), "Copyright")
+}
+
+func TestSanitize(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "photo.jpg"), testJPEG(t), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "photo.png"), testPNG(t), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "trailer.jpg"),
+		append(testJPEG(t), []byte("SecretCam trailer")...), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "notes.txt"), []byte("SecretCam"), 0644))
+
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Sanitize(logger, tempdir))
+	defer ts.Close()
+
+	testData := []struct {
+		uri         string
+		code        int
+		contentType string
+		leaked      bool
+	}{
+		{uri: "/photo.jpg", code: 200, contentType: "image/jpeg"},
+		{uri: "/photo.png", code: 200, contentType: "image/png"},
+		{uri: "/trailer.jpg", code: 200, contentType: "image/jpeg"},
+		{uri: "/notes.txt", code: 200, contentType: "text/plain; charset=utf-8", leaked: true},
+		{uri: "/missing.jpg", code: 404},
+		{uri: "/", code: 403},
+	}
+	for _, test := range testData {
+		t.Run(test.uri, func(t *testing.T) {
+			res, err := http.Get(ts.URL + test.uri)
+			require.NoError(t, err)
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, test.code, res.StatusCode)
+			if test.code != 200 {
+				return
+			}
+			assert.Equal(t, test.contentType, res.Header.Get("Content-Type"))
+			assert.Equal(t, test.leaked, bytes.Contains(body, []byte("SecretCam")))
+		})
+	}
+}
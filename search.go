@@ -0,0 +1,385 @@
+package dandler
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Search watches a directory - and sub directories - and keeps an index of
+// every file and directory within, by name, path and sidecar metadata (see
+// IndexMeta). Requests search below the requested directory, and the results
+// are sent as with Index - to the template, or as JSON. Ignored paths are not
+// indexed, and symlinks are not followed.
+//
+// The search is given with query parameters:
+//
+//	q    words that must all match:
+//	       beach    found anywhere in the name, path or metadata
+//	       bea*     a word starting with bea
+//	       ~beech   a word within a typo or two of beech
+//	       tag:sea  tagged with sea
+//	tag  a tag the results must have - may be given more than once
+//
+// Results are sorted with those matching on their name or title first - the
+// sort, order, filter, page and limit parameters from Index also work.
+//
+// The initial scan happens in the background - requests wait for it to finish.
+func Search(logger *log.Logger, basepath string, done <-chan struct{}, templ *template.Template, opts ...Option) http.Handler {
+	o := buildOptions(opts)
+	binder, err := bindIndexTemplate(logger, templ, o)
+	if err != nil {
+		logger.Printf("failed to load default template - %v", err)
+		return ResponseCode(500, "failed to initialize Search - %v", err)
+	}
+
+	stat, err := os.Stat(basepath)
+	if err == nil && !stat.IsDir() {
+		err = fmt.Errorf("not a directory: %s", basepath)
+	}
+	if err != nil {
+		logger.Printf("failed to index directory [%s] - %v", basepath, err)
+		return ResponseCode(500, "failed to initialize Search - %v", err)
+	}
+	idx := &searchIndex{
+		basePath: basepath,
+		ignore:   newListIgnorer(http.Dir(basepath), o),
+		meta:     newMetaCache(logger),
+		ready:    make(chan struct{}),
+		docs:     make(map[string]searchDoc),
+	}
+
+	w, err := watchChanges(basepath, done)
+	if err != nil {
+		logger.Printf("failed to watch directory [%s] - %v", basepath, err)
+		return ResponseCode(500, "failed to initialize Search - %v", err)
+	}
+	w.subscribeQueued(idx.update)
+
+	go idx.scan()
+
+	return searchHandler{idx: idx, basePath: basepath, l: logger, templ: binder, pageSize: o.pageSize}
+}
+
+type searchHandler struct {
+	idx      *searchIndex
+	basePath string
+	l        *log.Logger
+	templ    *templateBinder
+	pageSize int
+}
+
+func (h searchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	query, err := parseListingQuery(values, h.pageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.l.Printf("400 - bad listing query: %s - %s", r.URL.RawQuery, err)
+		return
+	}
+	if values.Get("sort") == "" {
+		query.sort = "relevance"
+	}
+
+	select {
+	case <-h.idx.ready:
+	case <-r.Context().Done():
+		return
+	}
+
+	data := IndexData{Path: path.Clean("/" + r.URL.Path), Query: values.Get("q")}
+	data.Crumbs = breadcrumbs(data.Path)
+	if len(data.Crumbs) > 1 {
+		data.Parent = data.Crumbs[len(data.Crumbs)-2].Href
+	}
+
+	results := h.idx.search(data.Path, parseSearch(values.Get("q"), values["tag"]))
+	data.Entries, data.Page = query.apply(results, r.URL)
+	for i := range data.Entries {
+		data.Entries[i].probe(filepath.Join(h.basePath, filepath.FromSlash(data.Entries[i].Path)))
+		if data.Entries[i].IsDir {
+			data.Dirs = append(data.Dirs, data.Entries[i].Path)
+		} else {
+			data.Files = append(data.Files, data.Entries[i].Path)
+		}
+	}
+
+	renderIndex(w, r, h.l, h.templ, data)
+}
+
+// These are the kinds of searchTerm.
+const (
+	termText = iota
+	termPrefix
+	termFuzzy
+	termTag
+)
+
+// searchTerm is a single word of a search, in lower case.
+type searchTerm struct {
+	kind  int
+	value string
+}
+
+// parseSearch splits a search into terms - the syntax is described with
+// Search.
+func parseSearch(q string, tags []string) []searchTerm {
+	var terms []searchTerm
+	for _, word := range strings.Fields(strings.ToLower(q)) {
+		switch {
+		case strings.HasPrefix(word, "tag:") && len(word) > len("tag:"):
+			terms = append(terms, searchTerm{kind: termTag, value: word[len("tag:"):]})
+		case strings.HasPrefix(word, "~") && len(word) > 1:
+			terms = append(terms, searchTerm{kind: termFuzzy, value: word[1:]})
+		case strings.HasSuffix(word, "*") && len(word) > 1:
+			terms = append(terms, searchTerm{kind: termPrefix, value: strings.TrimRight(word, "*")})
+		default:
+			terms = append(terms, searchTerm{kind: termText, value: word})
+		}
+	}
+	for _, tag := range tags {
+		if tag != "" {
+			terms = append(terms, searchTerm{kind: termTag, value: strings.ToLower(tag)})
+		}
+	}
+	return terms
+}
+
+// searchDoc is an indexed file, with everything it can be found by.
+type searchDoc struct {
+	entry IndexEntry
+	text  string
+	words []string
+	title string
+	tags  map[string]bool
+}
+
+func newSearchDoc(entry IndexEntry) searchDoc {
+	doc := searchDoc{
+		entry: entry,
+		title: strings.ToLower(entry.Name + " " + entry.Title),
+		tags:  make(map[string]bool),
+	}
+	for _, tag := range entry.Tags {
+		doc.tags[strings.ToLower(tag)] = true
+	}
+	doc.text = strings.ToLower(strings.Join(append([]string{entry.Path, entry.Title,
+		entry.Description, entry.Alt}, entry.Tags...), " "))
+	doc.words = strings.FieldsFunc(doc.text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	return doc
+}
+
+// match reports if the term matches the document, and if it matched on the
+// name or title.
+func (doc searchDoc) match(term searchTerm) (bool, bool) {
+	switch term.kind {
+	case termTag:
+		return doc.tags[term.value], false
+	case termPrefix:
+		for _, word := range doc.words {
+			if strings.HasPrefix(word, term.value) {
+				return true, strings.Contains(doc.title, word)
+			}
+		}
+		return false, false
+	case termFuzzy:
+		allowed := 1
+		if len(term.value) > 5 {
+			allowed = 2
+		}
+		for _, word := range doc.words {
+			if editDistance(word, term.value) <= allowed {
+				return true, strings.Contains(doc.title, word)
+			}
+		}
+		return false, false
+	default:
+		return strings.Contains(doc.text, term.value), strings.Contains(doc.title, term.value)
+	}
+}
+
+// editDistance is the Levenshtein distance between two words.
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		current := make([]int, len(br)+1)
+		current[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			current[j] = min3(prev[j]+1, current[j-1]+1, prev[j-1]+cost)
+		}
+		prev = current
+	}
+	return prev[len(br)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// searchIndex holds a searchDoc for everything below a location, keyed by
+// the path relative to that location.
+type searchIndex struct {
+	basePath string
+	ignore   *ignorer
+	meta     *metaCache
+	ready    chan struct{}
+
+	lock sync.RWMutex
+	docs map[string]searchDoc
+}
+
+// scan indexes everything below the base path, then marks the index ready.
+func (idx *searchIndex) scan() {
+	defer close(idx.ready)
+	idx.walk("/")
+}
+
+// walk indexes every directory from start down.
+func (idx *searchIndex) walk(start string) {
+	root := filepath.Join(idx.basePath, filepath.FromSlash(start))
+	filepath.Walk(root, func(loc string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(idx.basePath, loc)
+		if err != nil {
+			return nil
+		}
+		dirPath := path.Clean("/" + filepath.ToSlash(rel))
+		if idx.ignore.ignored(dirPath, true) {
+			return filepath.SkipDir
+		}
+		idx.refresh(dirPath)
+		return nil
+	})
+}
+
+// refresh indexes everything directly within a directory again - sidecars
+// may describe any file within it, so one change can affect any entry.
+func (idx *searchIndex) refresh(dirPath string) {
+	location := filepath.Join(idx.basePath, filepath.FromSlash(dirPath))
+	f, err := os.Open(location)
+	if err != nil {
+		return
+	}
+	contents, err := f.Readdir(0)
+	f.Close()
+	if err != nil {
+		return
+	}
+
+	entries, names := listEntries(idx.basePath, dirPath, contents, idx.ignore, SymlinksIgnore)
+	idx.meta.describe(location, names, entries)
+
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	for p := range idx.docs {
+		if path.Dir(p) == dirPath {
+			delete(idx.docs, p)
+		}
+	}
+	for _, entry := range entries {
+		idx.docs[entry.Path] = newSearchDoc(entry)
+	}
+}
+
+// remove drops the given path - and anything below it - from the index.
+func (idx *searchIndex) remove(name string) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	delete(idx.docs, name)
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	for each := range idx.docs {
+		if strings.HasPrefix(each, prefix) {
+			delete(idx.docs, each)
+		}
+	}
+}
+
+func (idx *searchIndex) update(c change) {
+	if c.Path == "/" {
+		return
+	}
+	stat, err := os.Lstat(filepath.Join(idx.basePath, filepath.FromSlash(c.Path)))
+	switch {
+	case err != nil:
+		idx.remove(c.Path)
+	case stat.IsDir() && (c.Op == changeAdd || c.Op == changeRename):
+		idx.walk(c.Path)
+	}
+	idx.refresh(path.Dir(c.Path))
+}
+
+// search returns the entries below dirPath matching every term - those that
+// matched on their name or title most often come first.
+func (idx *searchIndex) search(dirPath string, terms []searchTerm) []IndexEntry {
+	if len(terms) == 0 {
+		return []IndexEntry{}
+	}
+	prefix := strings.TrimSuffix(dirPath, "/") + "/"
+
+	type result struct {
+		entry IndexEntry
+		score int
+	}
+	var results []result
+
+	idx.lock.RLock()
+	for p, doc := range idx.docs {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		score := 0
+		matched := true
+		for _, term := range terms {
+			ok, inTitle := doc.match(term)
+			if !ok {
+				matched = false
+				break
+			}
+			if inTitle {
+				score++
+			}
+		}
+		if matched {
+			results = append(results, result{entry: doc.entry, score: score})
+		}
+	}
+	idx.lock.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].entry.Path < results[j].entry.Path
+	})
+	entries := make([]IndexEntry, len(results))
+	for i := range results {
+		entries[i] = results[i].entry
+	}
+	return entries
+}
@@ -0,0 +1,118 @@
+package dandler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("beach", "beach"))
+	assert.Equal(t, 1, editDistance("beach", "beech"))
+	assert.Equal(t, 2, editDistance("sunset", "sonsat"))
+	assert.Equal(t, 3, editDistance("", "sea"))
+}
+
+func TestParseSearch(t *testing.T) {
+	assert.Equal(t, []searchTerm{
+		{kind: termText, value: "beach"},
+		{kind: termPrefix, value: "sun"},
+		{kind: termFuzzy, value: "beech"},
+		{kind: termTag, value: "sea"},
+		{kind: termTag, value: "sand"},
+	}, parseSearch("Beach sun* ~beech tag:Sea", []string{"Sand", ""}))
+	assert.Empty(t, parseSearch("  ", nil))
+}
+
+func TestSearch(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+
+	for name, content := range map[string]string{
+		"holiday/beach.jpg":          "x",
+		"holiday/beach.jpg.yaml":     "title: Sunset on the sand\ntags: [sea, sand]\n",
+		"holiday/hotel.jpg":          "x",
+		"holiday/.meta.yaml":         "hotel.jpg:\n  description: The view from the beach\n  tags: [sea]\n",
+		"work/sunglasses-review.txt": "x",
+		"work/.hidden-beach.txt":     "x",
+	} {
+		location := filepath.Join(tempdir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(location), 0755))
+		require.NoError(t, ioutil.WriteFile(location, []byte(content), 0644))
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Search(logger, tempdir, done, nil))
+	defer ts.Close()
+
+	search := func(uri string, values url.Values) []string {
+		res, err := http.Get(ts.URL + uri + "?format=json&" + values.Encode())
+		require.NoError(t, err)
+		var data IndexData
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&data))
+		res.Body.Close()
+		assert.Equal(t, values.Get("q"), data.Query)
+		paths := []string{}
+		for _, each := range data.Entries {
+			paths = append(paths, each.Path)
+		}
+		return paths
+	}
+
+	var testData = []struct {
+		uri      string
+		values   url.Values
+		expected []string
+	}{
+		{uri: "/", values: url.Values{}, expected: []string{}},
+		{uri: "/", values: url.Values{"q": {"beach"}},
+			expected: []string{"/holiday/beach.jpg", "/holiday/hotel.jpg"}},
+		{uri: "/", values: url.Values{"q": {"sun*"}},
+			expected: []string{"/holiday/beach.jpg", "/work/sunglasses-review.txt"}},
+		{uri: "/", values: url.Values{"q": {"~sandd"}}, expected: []string{"/holiday/beach.jpg"}},
+		{uri: "/", values: url.Values{"q": {"tag:sea"}},
+			expected: []string{"/holiday/beach.jpg", "/holiday/hotel.jpg"}},
+		{uri: "/", values: url.Values{"tag": {"sea", "sand"}}, expected: []string{"/holiday/beach.jpg"}},
+		{uri: "/", values: url.Values{"q": {"view beach"}}, expected: []string{"/holiday/hotel.jpg"}},
+		{uri: "/", values: url.Values{"q": {"holiday"}},
+			expected: []string{"/holiday", "/holiday/beach.jpg", "/holiday/hotel.jpg"}},
+		{uri: "/work/", values: url.Values{"q": {"sun*"}}, expected: []string{"/work/sunglasses-review.txt"}},
+		{uri: "/", values: url.Values{"q": {"yaml"}}, expected: []string{}},
+		{uri: "/", values: url.Values{"q": {"beach"}, "limit": {"1"}}, expected: []string{"/holiday/beach.jpg"}},
+		{uri: "/", values: url.Values{"q": {"beach"}, "sort": {"name"}, "order": {"desc"}},
+			expected: []string{"/holiday/hotel.jpg", "/holiday/beach.jpg"}},
+	}
+	for _, test := range testData {
+		assert.Equal(t, test.expected, search(test.uri, test.values), test.values.Encode())
+	}
+
+	// changes are picked up as they happen
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "work", "beach-budget.txt"), nil, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "holiday", "hotel.jpg.json"), []byte(`{"tags": ["pool"]}`), 0644))
+	require.NoError(t, os.Remove(filepath.Join(tempdir, "holiday", "beach.jpg")))
+	settled := func() bool {
+		return assert.ObjectsAreEqual([]string{"/holiday/hotel.jpg"}, search("/", url.Values{"tag": {"pool"}})) &&
+			assert.ObjectsAreEqual([]string{"/work/beach-budget.txt"}, search("/", url.Values{"q": {"budget"}})) &&
+			assert.ObjectsAreEqual([]string{}, search("/", url.Values{"q": {"sunset"}}))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !settled() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"/holiday/hotel.jpg"}, search("/", url.Values{"tag": {"pool"}}))
+	assert.Equal(t, []string{"/work/beach-budget.txt"}, search("/", url.Values{"q": {"budget"}}))
+	assert.Equal(t, []string{}, search("/", url.Values{"q": {"sunset"}}))
+}
@@ -0,0 +1,280 @@
+package dandler
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jakdept/dir"
+)
+
+// sitemapMaxURLs is the most URLs the sitemap protocol allows in one file.
+var sitemapMaxURLs = 50000
+
+// WithBaseURL sets the address the site is reached at, for handlers that send
+// absolute links - such as Sitemap and Feed. Without it, links are built from
+// the Host of each request.
+func WithBaseURL(base string) Option {
+	return func(o *options) {
+		o.baseURL = base
+	}
+}
+
+// baseURL returns the address to build absolute links from - the one given
+// with WithBaseURL, or else where the request was sent to.
+func baseURL(configured string, r *http.Request) *url.URL {
+	if configured != "" {
+		if base, err := url.Parse(configured); err == nil && base.IsAbs() {
+			return base
+		}
+	}
+	base := &url.URL{Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		base.Scheme = "https"
+	}
+	return base
+}
+
+// absoluteURL turns a link from the root of the site into one that can be used
+// from anywhere - keeping any path the base has. Links that are already
+// absolute are left alone.
+func absoluteURL(base *url.URL, href string) string {
+	ref, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if ref.IsAbs() {
+		return ref.String()
+	}
+	result := *base
+	result.Path = strings.TrimSuffix(base.Path, "/") + path.Clean("/"+ref.Path)
+	if strings.HasSuffix(ref.Path, "/") && !strings.HasSuffix(result.Path, "/") {
+		result.Path += "/"
+	}
+	result.RawPath = ""
+	result.RawQuery = ref.RawQuery
+	result.Fragment = ""
+	return result.String()
+}
+
+// Sitemap watches a directory - and sub directories - and sends a sitemap.xml
+// listing every directory and file within, with the time each was last
+// modified. Ignored paths are left out, as with Index - see WithIgnore.
+//
+// Past 50,000 URLs, a sitemap index is sent instead, linking to each part of
+// the sitemap with ?part=N.
+//
+// What is listed is kept in memory until anything within the directory
+// changes, so that each part does not look through everything again.
+func Sitemap(logger *log.Logger, basepath string, done <-chan struct{}, opts ...Option) http.Handler {
+	o := buildOptions(opts)
+	tracker, err := dir.Watch(basepath)
+	if err != nil {
+		logger.Printf("failed to watch directory [%s] - %v", basepath, err)
+		return ResponseCode(500, "failed to initialize Sitemap - %v", err)
+	}
+	go func() {
+		<-done
+		tracker.Close()
+	}()
+
+	w, err := watchChanges(basepath, done)
+	if err != nil {
+		logger.Printf("failed to watch directory [%s] - %v", basepath, err)
+		return ResponseCode(500, "failed to initialize Sitemap - %v", err)
+	}
+	cache := &sitemapCache{}
+	w.subscribe(cache.invalidate)
+
+	return sitemapHandler{basePath: basepath, l: logger, dir: tracker, baseURL: o.baseURL,
+		ignore: newListIgnorer(http.Dir(basepath), o), symlinks: o.symlinks, cache: cache}
+}
+
+type sitemapHandler struct {
+	basePath string
+	l        *log.Logger
+	dir      *dir.Tracker
+	baseURL  string
+	ignore   *ignorer
+	symlinks SymlinkPolicy
+	cache    *sitemapCache
+}
+
+// sitemapCache holds the entries collected for the sitemap, until something
+// changes.
+type sitemapCache struct {
+	lock       sync.Mutex
+	generation int
+	entries    []sitemapEntry
+	valid      bool
+}
+
+// get returns the entries stored, if they are still current - along with a
+// marker to pass to put.
+func (c *sitemapCache) get() ([]sitemapEntry, int, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.entries, c.generation, c.valid
+}
+
+// put stores entries, unless something changed since they were collected.
+func (c *sitemapCache) put(generation int, entries []sitemapEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if generation == c.generation {
+		c.entries, c.valid = entries, true
+	}
+}
+
+// invalidate drops the entries stored - any change may show up in them.
+func (c *sitemapCache) invalidate(change) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.generation++
+	c.entries, c.valid = nil, false
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// sitemapEntry is a path to be listed, and when it last changed.
+type sitemapEntry struct {
+	href    string
+	modTime time.Time
+}
+
+func (h sitemapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	entries, generation, ok := h.cache.get()
+	if !ok {
+		entries = h.collect()
+		h.cache.put(generation, entries)
+	}
+	base := baseURL(h.baseURL, r)
+	parts := (len(entries) + sitemapMaxURLs - 1) / sitemapMaxURLs
+
+	var doc interface{}
+	raw := r.URL.Query().Get("part")
+	switch {
+	case raw == "" && parts <= 1:
+		doc = sitemapURLSet{URLs: h.urls(base, entries)}
+	case raw == "":
+		index := sitemapIndex{}
+		for part := 1; part <= parts; part++ {
+			chunk := entries[(part-1)*sitemapMaxURLs : minInt(part*sitemapMaxURLs, len(entries))]
+			link := &url.URL{Path: r.URL.Path, RawQuery: "part=" + strconv.Itoa(part)}
+			index.Sitemaps = append(index.Sitemaps, sitemapURL{
+				Loc:     absoluteURL(base, link.String()),
+				LastMod: latest(chunk).UTC().Format(time.RFC3339),
+			})
+		}
+		doc = index
+	default:
+		part, err := strconv.Atoi(raw)
+		if err != nil || part < 1 || part > parts {
+			http.Error(w, fmt.Sprintf("no such part: %s", raw), http.StatusNotFound)
+			h.l.Printf("404 - no such sitemap part: %s", raw)
+			return
+		}
+		chunk := entries[(part-1)*sitemapMaxURLs : minInt(part*sitemapMaxURLs, len(entries))]
+		doc = sitemapURLSet{URLs: h.urls(base, chunk)}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(doc); err != nil {
+		http.Error(w, fmt.Sprintf("error building response: %s", r.URL.Path), http.StatusInternalServerError)
+		h.l.Printf("500 - error responding: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// collect lists every directory tracked, and the files within them, sorted
+// by path so that each part of a split sitemap stays the same.
+func (h sitemapHandler) collect() []sitemapEntry {
+	var entries []sitemapEntry
+	for _, dirPath := range h.dir.List() {
+		if h.ignore.ignored(dirPath, true) {
+			continue
+		}
+		location := filepath.Join(h.basePath, filepath.FromSlash(dirPath))
+		f, err := os.Open(location)
+		if err != nil {
+			continue
+		}
+		stat, err := f.Stat()
+		if err != nil {
+			f.Close()
+			continue
+		}
+		contents, err := f.Readdir(0)
+		f.Close()
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, sitemapEntry{href: dirHref(dirPath), modTime: stat.ModTime()})
+		files, _ := listEntries(h.basePath, dirPath, contents, h.ignore, h.symlinks)
+		for _, each := range files {
+			// directories are listed from the tracker instead
+			if !each.IsDir {
+				entries = append(entries, sitemapEntry{href: each.Href, modTime: each.ModTime})
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].href < entries[j].href })
+	return entries
+}
+
+func (h sitemapHandler) urls(base *url.URL, entries []sitemapEntry) []sitemapURL {
+	urls := make([]sitemapURL, 0, len(entries))
+	for _, each := range entries {
+		urls = append(urls, sitemapURL{
+			Loc:     absoluteURL(base, each.href),
+			LastMod: each.modTime.UTC().Format(time.RFC3339),
+		})
+	}
+	return urls
+}
+
+// latest returns when the most recently changed entry changed.
+func latest(entries []sitemapEntry) time.Time {
+	var newest time.Time
+	for _, each := range entries {
+		if each.modTime.After(newest) {
+			newest = each.modTime
+		}
+	}
+	return newest
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
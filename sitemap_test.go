@@ -0,0 +1,132 @@
+package dandler
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sitemapFixture(t *testing.T) string {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range []string{"a.txt", "photos/b.png", "photos/b.png.yaml", ".hidden/c.txt", "skip.log"} {
+		location := filepath.Join(tempdir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(location), 0755))
+		require.NoError(t, ioutil.WriteFile(location, []byte("ohai"), 0644))
+		require.NoError(t, os.Chtimes(location, mtime, mtime))
+	}
+	for _, name := range []string{"photos", ".hidden", "."} {
+		require.NoError(t, os.Chtimes(filepath.Join(tempdir, name), mtime, mtime))
+	}
+	return tempdir
+}
+
+func TestSitemap(t *testing.T) {
+	tempdir := sitemapFixture(t)
+	defer os.RemoveAll(tempdir)
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Sitemap(logger, tempdir, done,
+		WithBaseURL("https://example.com/files/"), WithIgnore("*.log")))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/sitemap.xml")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/xml; charset=utf-8", res.Header.Get("Content-Type"))
+
+	var set sitemapURLSet
+	require.NoError(t, xml.NewDecoder(res.Body).Decode(&set))
+	assert.Equal(t, []sitemapURL{
+		{Loc: "https://example.com/files/", LastMod: "2020-01-01T00:00:00Z"},
+		{Loc: "https://example.com/files/a.txt", LastMod: "2020-01-01T00:00:00Z"},
+		{Loc: "https://example.com/files/photos/", LastMod: "2020-01-01T00:00:00Z"},
+		{Loc: "https://example.com/files/photos/b.png", LastMod: "2020-01-01T00:00:00Z"},
+	}, set.URLs)
+}
+
+func TestSitemap_split(t *testing.T) {
+	defer func(max int) { sitemapMaxURLs = max }(sitemapMaxURLs)
+	sitemapMaxURLs = 3
+
+	tempdir := sitemapFixture(t)
+	defer os.RemoveAll(tempdir)
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Sitemap(logger, tempdir, done))
+	defer ts.Close()
+
+	get := func(uri string, doc interface{}) int {
+		res, err := http.Get(ts.URL + uri)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			require.NoError(t, xml.NewDecoder(res.Body).Decode(doc))
+		}
+		return res.StatusCode
+	}
+
+	var index sitemapIndex
+	require.Equal(t, http.StatusOK, get("/sitemap.xml", &index))
+	require.Len(t, index.Sitemaps, 2)
+	assert.Equal(t, ts.URL+"/sitemap.xml?part=1", index.Sitemaps[0].Loc)
+	assert.Equal(t, ts.URL+"/sitemap.xml?part=2", index.Sitemaps[1].Loc)
+	assert.Equal(t, "2020-01-01T00:00:00Z", index.Sitemaps[0].LastMod)
+
+	var first, second sitemapURLSet
+	require.Equal(t, http.StatusOK, get("/sitemap.xml?part=1", &first))
+	require.Equal(t, http.StatusOK, get("/sitemap.xml?part=2", &second))
+	assert.Len(t, first.URLs, 3)
+	require.Len(t, second.URLs, 2)
+	assert.Equal(t, ts.URL+"/skip.log", second.URLs[1].Loc)
+
+	for _, part := range []string{"0", "3", "one"} {
+		assert.Equal(t, http.StatusNotFound, get("/sitemap.xml?part="+part, &first), part)
+	}
+}
+
+func TestSitemap_cache(t *testing.T) {
+	tempdir := sitemapFixture(t)
+	defer os.RemoveAll(tempdir)
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	handler := Sitemap(logger, tempdir, done)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	get := func() []sitemapURL {
+		res, err := http.Get(ts.URL + "/sitemap.xml")
+		require.NoError(t, err)
+		defer res.Body.Close()
+		var set sitemapURLSet
+		require.NoError(t, xml.NewDecoder(res.Body).Decode(&set))
+		return set.URLs
+	}
+
+	assert.Len(t, get(), 5)
+	_, _, cached := handler.(sitemapHandler).cache.get()
+	assert.True(t, cached, "entries are kept between requests")
+
+	// the entries are collected again once anything changes
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "photos", "new.png"), []byte("ohai"), 0644))
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && len(get()) != 6 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Len(t, get(), 6)
+}
@@ -0,0 +1,101 @@
+package dandler
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Srcset describes the thumbnails available for each image, so that templates
+// can build responsive <img> tags.
+//
+// Pattern is the URL of a thumbnail, with {width} replaced by the width and
+// {path} replaced by the escaped path to the original. With a Thumbnail handler
+// for each width mounted at /thumbs/<width>/ that would be
+// "/thumbs/{width}{path}.png".
+//
+// Sizes is used as the sizes attribute. If empty, the largest width is used.
+type Srcset struct {
+	Widths  []int
+	Pattern string
+	Sizes   string
+}
+
+// WithSrcset sets the thumbnails used by the template functions Index provides.
+func WithSrcset(s Srcset) Option {
+	return func(o *options) {
+		o.srcset = s
+	}
+}
+
+// URL returns the address of the thumbnail of src at the given width. If no
+// Pattern is set, the escaped src is returned.
+func (s Srcset) URL(src string, width int) string {
+	escaped := (&url.URL{Path: src}).EscapedPath()
+	if s.Pattern == "" {
+		return escaped
+	}
+	return strings.NewReplacer(
+		"{width}", strconv.Itoa(width),
+		"{path}", escaped,
+	).Replace(s.Pattern)
+}
+
+// Attr returns the value of a srcset attribute for src - each width's thumbnail
+// with a width descriptor.
+func (s Srcset) Attr(src string) string {
+	if s.Pattern == "" {
+		return ""
+	}
+	candidates := make([]string, 0, len(s.Widths))
+	for _, width := range s.Widths {
+		candidates = append(candidates, fmt.Sprintf("%s %dw", s.URL(src, width), width))
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// SizesAttr returns the value of a sizes attribute.
+func (s Srcset) SizesAttr() string {
+	if s.Sizes != "" || len(s.Widths) == 0 {
+		return s.Sizes
+	}
+	largest := s.Widths[0]
+	for _, width := range s.Widths {
+		if width > largest {
+			largest = width
+		}
+	}
+	return fmt.Sprintf("%dpx", largest)
+}
+
+// Funcs returns template functions using this Srcset:
+//
+//	thumb  <path> <width>  the URL of one thumbnail
+//	srcset <path>          the value for a srcset attribute
+//	sizes                  the value for a sizes attribute
+//
+// For example:
+//
+//	<img src="{{ thumb . 250 }}" srcset="{{ srcset . }}" sizes="{{ sizes }}">
+func (s Srcset) Funcs() template.FuncMap {
+	return template.FuncMap{
+		"thumb":  s.URL,
+		"srcset": s.Attr,
+		"sizes":  s.SizesAttr,
+	}
+}
+
+// IndexFuncs returns every template function Index provides. They must be
+// added to a template before it is parsed - Index replaces them with the
+// configured versions.
+//
+//	template.New("index").Funcs(dandler.IndexFuncs()).Parse(...)
+//
+// Along with those from Srcset, humanize formats a size in bytes.
+func IndexFuncs() template.FuncMap {
+	funcs := Srcset{}.Funcs()
+	funcs["humanize"] = humanize
+	return funcs
+}
@@ -0,0 +1,60 @@
+package dandler
+
+import (
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSrcset(t *testing.T) {
+	s := Srcset{Widths: []int{250, 500}, Pattern: "/thumbs/{width}{path}.png"}
+
+	assert.Equal(t, "/thumbs/250/dir/a%20b.jpg.png", s.URL("/dir/a b.jpg", 250))
+	assert.Equal(t, "/thumbs/250/a.jpg.png 250w, /thumbs/500/a.jpg.png 500w", s.Attr("/a.jpg"))
+	assert.Equal(t, "500px", s.SizesAttr())
+
+	s.Sizes = "(max-width: 600px) 100vw, 250px"
+	assert.Equal(t, "(max-width: 600px) 100vw, 250px", s.SizesAttr())
+
+	var empty Srcset
+	assert.Equal(t, "/a%20b.jpg", empty.URL("/a b.jpg", 250))
+	assert.Equal(t, "", empty.Attr("/a.jpg"))
+	assert.Equal(t, "", empty.SizesAttr())
+}
+
+func TestIndex_srcset(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "a b.jpg"), []byte{}, 0644))
+
+	templ := template.Must(template.New("test").Funcs(IndexFuncs()).Parse(
+		`{{ range .Files }}<img src="{{ thumb . 250 }}" srcset="{{ srcset . }}" sizes="{{ sizes }}">{{ end }}`))
+
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	ts := httptest.NewServer(Index(logger, tempdir, done, templ, WithSrcset(Srcset{
+		Widths:  []int{250, 500},
+		Pattern: "/thumbs/{width}{path}.png",
+	})))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+
+	assert.Equal(t, `<img src="/thumbs/250/a%20b.jpg.png" `+
+		`srcset="/thumbs/250/a%20b.jpg.png 250w, /thumbs/500/a%20b.jpg.png 500w" sizes="500px">`,
+		string(body))
+}
@@ -0,0 +1,139 @@
+package dandler
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy decides how symlinks below a served directory are treated.
+type SymlinkPolicy int
+
+const (
+	// SymlinksFollow follows symlinks wherever they lead. This is the
+	// default, as it is what serving straight from the filesystem does.
+	SymlinksFollow SymlinkPolicy = iota
+	// SymlinksWithinRoot follows symlinks as long as they lead somewhere
+	// within the directory being served.
+	SymlinksWithinRoot
+	// SymlinksIgnore treats symlinks as if they were not there.
+	SymlinksIgnore
+)
+
+// WithSymlinks sets how symlinks are treated when listing directories,
+// routing with DirSplit, and serving files. Broken symlinks, and symlinks
+// that loop, are never followed. Use SymlinksWithinRoot to keep anything
+// outside the served directory from being reached through a symlink.
+func WithSymlinks(policy SymlinkPolicy) Option {
+	return func(o *options) {
+		o.symlinks = policy
+	}
+}
+
+// errSymlink is returned for a path the symlink policy does not allow.
+var errSymlink = errors.New("symlink not allowed")
+
+// resolve finds the file at urlPath below root, following symlinks as the
+// policy allows. It returns where the file can be opened, and the details of
+// the file - not of the symlink.
+func (p SymlinkPolicy) resolve(root, urlPath string) (string, os.FileInfo, error) {
+	urlPath = path.Clean("/" + urlPath)
+	location := filepath.Join(root, filepath.FromSlash(urlPath))
+
+	switch p {
+	case SymlinksIgnore:
+		// every step is checked, as a symlink to a parent directory would
+		// otherwise be followed
+		current := root
+		for _, part := range strings.Split(strings.Trim(urlPath, "/"), "/") {
+			if part == "" {
+				continue
+			}
+			current = filepath.Join(current, part)
+			info, err := os.Lstat(current)
+			if err != nil {
+				return "", nil, err
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				return "", nil, errSymlink
+			}
+		}
+		info, err := os.Stat(location)
+		return location, info, err
+
+	case SymlinksWithinRoot:
+		resolved, err := filepath.EvalSymlinks(location)
+		if err != nil {
+			return "", nil, err
+		}
+		base, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return "", nil, err
+		}
+		if resolved != base && !strings.HasPrefix(resolved, strings.TrimSuffix(base, string(filepath.Separator))+string(filepath.Separator)) {
+			return "", nil, errSymlink
+		}
+		info, err := os.Stat(resolved)
+		return resolved, info, err
+
+	default:
+		info, err := os.Stat(location)
+		return location, info, err
+	}
+}
+
+// open opens the file at urlPath below root, if the policy allows it.
+func (p SymlinkPolicy) open(root, urlPath string) (*os.File, error) {
+	location, _, err := p.resolve(root, urlPath)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(location)
+}
+
+// resolveEntry returns the details of an entry read from the directory at
+// urlPath - if it is a symlink, of what it leads to. ok is false for symlinks
+// that the policy does not allow, or that cannot be followed.
+func (p SymlinkPolicy) resolveEntry(root, urlPath string, info os.FileInfo) (string, os.FileInfo, bool) {
+	entryPath := path.Join(urlPath, info.Name())
+	if info.Mode()&os.ModeSymlink == 0 {
+		return filepath.Join(root, filepath.FromSlash(entryPath)), info, true
+	}
+	location, target, err := p.resolve(root, entryPath)
+	if err != nil {
+		return "", nil, false
+	}
+	return location, linkInfo{FileInfo: target, name: info.Name()}, true
+}
+
+// linkInfo describes what a symlink leads to, under the name of the symlink.
+type linkInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (l linkInfo) Name() string {
+	return l.name
+}
+
+// isLoop reports if dir is the same directory as one of its ancestors - as
+// happens when following a symlink to a parent directory.
+func isLoop(ancestors []os.FileInfo, dir os.FileInfo) bool {
+	for _, each := range ancestors {
+		if os.SameFile(unwrapInfo(each), unwrapInfo(dir)) {
+			return true
+		}
+	}
+	return false
+}
+
+// unwrapInfo undoes linkInfo, as os.SameFile only understands the FileInfo
+// from os.Stat.
+func unwrapInfo(info os.FileInfo) os.FileInfo {
+	if link, ok := info.(linkInfo); ok {
+		return link.FileInfo
+	}
+	return info
+}
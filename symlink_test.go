@@ -0,0 +1,253 @@
+package dandler
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// symlinkFixture builds a directory to serve, with symlinks that stay within
+// it, lead out of it, are broken, and loop. The directory to serve is
+// returned, along with one to remove afterwards.
+func symlinkFixture(t *testing.T) (string, string) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	root := filepath.Join(tempdir, "root")
+
+	for name, content := range map[string]string{
+		"root/real/file.txt":     "real",
+		"outside/secret.txt":     "secret",
+		"outside/deeper/too.txt": "too",
+	} {
+		location := filepath.Join(tempdir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(location), 0755))
+		require.NoError(t, ioutil.WriteFile(location, []byte(content), 0644))
+	}
+
+	for link, target := range map[string]string{
+		"inside":       "real",
+		"file-link":    filepath.Join("real", "file.txt"),
+		"outside":      filepath.Join(tempdir, "outside"),
+		"broken":       "nowhere",
+		"self":         "self",
+		"real/parent":  "..",
+		"real/itself":  ".",
+		"real/escapes": filepath.Join("..", "..", "outside", "secret.txt"),
+	} {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(link))); err != nil {
+			os.RemoveAll(tempdir)
+			t.Skipf("cannot create symlinks - %v", err)
+		}
+	}
+	return root, tempdir
+}
+
+func TestSymlinkPolicy(t *testing.T) {
+	root, tempdir := symlinkFixture(t)
+	defer os.RemoveAll(tempdir)
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+
+	var testData = []struct {
+		name    string
+		policy  SymlinkPolicy
+		served  map[string]int
+		listing []string
+		routed  map[string]string
+	}{
+		{
+			name:   "ignore",
+			policy: SymlinksIgnore,
+			served: map[string]int{
+				"/real/file.txt":             200,
+				"/file-link":                 404,
+				"/inside/file.txt":           404,
+				"/outside/secret.txt":        404,
+				"/real/escapes":              404,
+				"/broken":                    404,
+				"/self":                      404,
+				"/real/parent/real/file.txt": 404,
+			},
+			listing: []string{"/real/"},
+			routed:  map[string]string{"/real": "folder", "/inside": "other", "/outside": "other"},
+		}, {
+			name:   "within root",
+			policy: SymlinksWithinRoot,
+			served: map[string]int{
+				"/real/file.txt":             200,
+				"/file-link":                 200,
+				"/inside/file.txt":           200,
+				"/outside/secret.txt":        404,
+				"/real/escapes":              404,
+				"/broken":                    404,
+				"/self":                      404,
+				"/real/parent/real/file.txt": 200,
+			},
+			listing: []string{"/file-link", "/inside/", "/real/"},
+			routed:  map[string]string{"/real": "folder", "/inside": "folder", "/outside": "other"},
+		}, {
+			name:   "follow",
+			policy: SymlinksFollow,
+			served: map[string]int{
+				"/real/file.txt":             200,
+				"/file-link":                 200,
+				"/inside/file.txt":           200,
+				"/outside/secret.txt":        200,
+				"/real/escapes":              200,
+				"/broken":                    404,
+				"/self":                      404,
+				"/real/parent/real/file.txt": 200,
+			},
+			listing: []string{"/file-link", "/inside/", "/outside/", "/real/"},
+			routed:  map[string]string{"/real": "folder", "/inside": "folder", "/outside": "folder"},
+		},
+	}
+
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			opts := []Option{WithSymlinks(test.policy)}
+
+			files := httptest.NewServer(ContentType(logger, root, opts...))
+			defer files.Close()
+			for uri, code := range test.served {
+				res, err := http.Get(files.URL + uri)
+				require.NoError(t, err)
+				res.Body.Close()
+				assert.Equal(t, code, res.StatusCode, uri)
+			}
+
+			sanitized := httptest.NewServer(Sanitize(logger, root, opts...))
+			defer sanitized.Close()
+			for uri, code := range test.served {
+				res, err := http.Get(sanitized.URL + uri)
+				require.NoError(t, err)
+				res.Body.Close()
+				assert.Equal(t, code, res.StatusCode, "sanitized %s", uri)
+			}
+
+			index := httptest.NewServer(Index(logger, root, done, template.Must(template.New("test").Parse("")), opts...))
+			defer index.Close()
+			res, err := http.Get(index.URL + "/?format=json")
+			require.NoError(t, err)
+			var data IndexData
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&data))
+			res.Body.Close()
+			var listing []string
+			for _, each := range data.Entries {
+				listing = append(listing, each.Href)
+			}
+			sort.Strings(listing)
+			assert.Equal(t, test.listing, listing)
+
+			split := httptest.NewServer(DirSplit(logger, root, done, Success("folder"), Success("other"), opts...))
+			defer split.Close()
+			for uri, expected := range test.routed {
+				res, err := http.Get(split.URL + uri)
+				require.NoError(t, err)
+				body, err := ioutil.ReadAll(res.Body)
+				res.Body.Close()
+				require.NoError(t, err)
+				assert.Equal(t, expected, string(body), uri)
+			}
+		})
+	}
+}
+
+func TestArchive_symlinkLoops(t *testing.T) {
+	root, tempdir := symlinkFixture(t)
+	defer os.RemoveAll(tempdir)
+	logger := log.New(ioutil.Discard, "", 0)
+
+	ts := httptest.NewServer(Archive(logger, root, WithSymlinks(SymlinksFollow)))
+	defer ts.Close()
+	res, err := http.Get(ts.URL + "/?download=zip")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var names []string
+	for name := range readZip(t, body) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{
+		"archive/file-link",
+		"archive/inside/escapes",
+		"archive/inside/file.txt",
+		"archive/outside/deeper/too.txt",
+		"archive/outside/secret.txt",
+		"archive/real/escapes",
+		"archive/real/file.txt",
+	}, names)
+}
+
+func TestThumbnail_symlinks(t *testing.T) {
+	root, tempdir := symlinkFixture(t)
+	defer os.RemoveAll(tempdir)
+	thumbs, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(thumbs)
+	logger := log.New(ioutil.Discard, "", 0)
+	opts := []Option{WithSymlinks(SymlinksWithinRoot), WithIgnore("*.txt")}
+
+	for name, handler := range map[string]http.Handler{
+		"Thumbnail":  Thumbnail(logger, 300, 250, root, thumbs, "png", opts...),
+		"ThumbCache": ThumbCache(logger, 300, 250, 1<<20, root, "test-symlinks", "png", opts...),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts := httptest.NewServer(handler)
+			defer ts.Close()
+
+			suffix := ""
+			if name == "Thumbnail" {
+				suffix = ".png"
+			}
+			for uri, code := range map[string]int{
+				"/real":               http.StatusForbidden,
+				"/real/file.txt":      http.StatusNotFound,
+				"/outside/secret.txt": http.StatusNotFound,
+				"/real/escapes":       http.StatusNotFound,
+				"/missing.png":        http.StatusNotFound,
+			} {
+				res, err := http.Get(ts.URL + uri + suffix)
+				require.NoError(t, err)
+				res.Body.Close()
+				assert.Equal(t, code, res.StatusCode, uri)
+			}
+		})
+	}
+}
+
+func TestSymlinkPolicy_default(t *testing.T) {
+	root, tempdir := symlinkFixture(t)
+	defer os.RemoveAll(tempdir)
+	logger := log.New(ioutil.Discard, "", 0)
+
+	// without WithSymlinks, symlinks are followed wherever they lead - as
+	// serving straight from the filesystem always has
+	ts := httptest.NewServer(ContentType(logger, root))
+	defer ts.Close()
+	for uri, code := range map[string]int{
+		"/outside/secret.txt": http.StatusOK,
+		"/real/escapes":       http.StatusOK,
+		"/self":               http.StatusNotFound,
+	} {
+		res, err := http.Get(ts.URL + uri)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, code, res.StatusCode, uri)
+	}
+}
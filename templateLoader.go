@@ -0,0 +1,193 @@
+package dandler
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TemplateSource provides a template to a handler each time it is needed, so
+// that the template can change while the handler is running.
+type TemplateSource interface {
+	Template() *template.Template
+}
+
+// WithTemplates sets where Index gets its template from, in place of the
+// template passed to it.
+func WithTemplates(src TemplateSource) Option {
+	return func(o *options) {
+		o.templates = src
+	}
+}
+
+type staticTemplate struct {
+	t *template.Template
+}
+
+func (s staticTemplate) Template() *template.Template {
+	return s.t
+}
+
+// TemplateLoader parses templates from files on disk, and parses them again
+// whenever they change. If parsing fails, the error is logged and the last
+// good template keeps being used.
+type TemplateLoader struct {
+	l        *log.Logger
+	patterns []string
+	funcs    template.FuncMap
+
+	lock    sync.RWMutex
+	current *template.Template
+	mtimes  map[string]time.Time
+}
+
+// LoadTemplates parses every file matching the glob patterns, then checks
+// them for changes every interval until done is closed. The first file
+// matched is the template executed - the rest can provide {{define}}s for it.
+//
+// The functions from IndexFuncs are available, along with funcs.
+func LoadTemplates(logger *log.Logger, done <-chan struct{}, interval time.Duration,
+	funcs template.FuncMap, patterns ...string) (*TemplateLoader, error) {
+	loader := &TemplateLoader{l: logger, patterns: patterns, funcs: IndexFuncs()}
+	for name, fn := range funcs {
+		loader.funcs[name] = fn
+	}
+
+	files, mtimes, err := loader.scan()
+	if err != nil {
+		return nil, err
+	}
+	loader.current, err = loader.parse(files)
+	if err != nil {
+		return nil, err
+	}
+	loader.mtimes = mtimes
+
+	if interval <= 0 {
+		interval = time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				loader.reload()
+			}
+		}
+	}()
+	return loader, nil
+}
+
+// Template returns the last template that parsed successfully.
+func (t *TemplateLoader) Template() *template.Template {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.current
+}
+
+// scan finds every file matching the patterns, in order, with their
+// modification times.
+func (t *TemplateLoader) scan() ([]string, map[string]time.Time, error) {
+	var files []string
+	mtimes := make(map[string]time.Time)
+	for _, pattern := range t.patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, nil, err
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if _, seen := mtimes[match]; seen {
+				continue
+			}
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, nil, err
+			}
+			files = append(files, match)
+			mtimes[match] = info.ModTime()
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("no templates match %v", t.patterns)
+	}
+	return files, mtimes, nil
+}
+
+func (t *TemplateLoader) parse(files []string) (*template.Template, error) {
+	return template.New(filepath.Base(files[0])).Funcs(t.funcs).ParseFiles(files...)
+}
+
+// reload parses the templates again if any file was changed, added or removed.
+func (t *TemplateLoader) reload() {
+	files, mtimes, err := t.scan()
+	if err != nil {
+		t.l.Printf("failed to reload templates %v - %v", t.patterns, err)
+		return
+	}
+
+	t.lock.RLock()
+	changed := len(mtimes) != len(t.mtimes)
+	for name, mtime := range mtimes {
+		if old, ok := t.mtimes[name]; !ok || !old.Equal(mtime) {
+			changed = true
+		}
+	}
+	t.lock.RUnlock()
+	if !changed {
+		return
+	}
+
+	parsed, err := t.parse(files)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	// remember the failed files too, so the error is only logged once per change
+	t.mtimes = mtimes
+	if err != nil {
+		t.l.Printf("failed to reload templates %v - keeping the last good one - %v", t.patterns, err)
+		return
+	}
+	t.current = parsed
+}
+
+// templateBinder adds the configured functions to the templates from a
+// source, only cloning when the source hands back a new template.
+type templateBinder struct {
+	src   TemplateSource
+	funcs template.FuncMap
+	l     *log.Logger
+
+	lock  sync.Mutex
+	last  *template.Template
+	bound *template.Template
+}
+
+func newTemplateBinder(logger *log.Logger, src TemplateSource, funcs template.FuncMap) *templateBinder {
+	return &templateBinder{src: src, funcs: funcs, l: logger}
+}
+
+func (b *templateBinder) Template() *template.Template {
+	current := b.src.Template()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if current == b.last {
+		return b.bound
+	}
+	b.last, b.bound = current, current
+	if clone, err := current.Clone(); err == nil {
+		b.bound = clone.Funcs(b.funcs)
+	} else {
+		b.l.Printf("could not add template functions - %v", err)
+	}
+	return b.bound
+}
@@ -0,0 +1,122 @@
+package dandler
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTemplate(t *testing.T, name, content string, mtime time.Time) {
+	require.NoError(t, ioutil.WriteFile(name, []byte(content), 0644))
+	// set the time explicitly, as filesystem timestamps may be coarse
+	require.NoError(t, os.Chtimes(name, mtime, mtime))
+}
+
+func execute(t *testing.T, templ *template.Template) string {
+	var buf bytes.Buffer
+	require.NoError(t, templ.Execute(&buf, nil))
+	return buf.String()
+}
+
+func TestTemplateLoader(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+
+	page := filepath.Join(tempdir, "page.html")
+	parts := filepath.Join(tempdir, "parts.html")
+	start := time.Now().Add(-time.Hour)
+	writeTemplate(t, page, `{{ template "greeting" }} {{ shout "gopher" }}`, start)
+	writeTemplate(t, parts, `{{ define "greeting" }}ohai{{ end }}`, start)
+
+	var logs bytes.Buffer
+	done := make(chan struct{})
+	defer close(done)
+	loader, err := LoadTemplates(log.New(&logs, "", 0), done, time.Hour,
+		template.FuncMap{"shout": func(s string) string { return s + "!" }}, page, parts)
+	require.NoError(t, err)
+	assert.Equal(t, "ohai gopher!", execute(t, loader.Template()))
+
+	// nothing changed, nothing reloaded
+	before := loader.Template()
+	loader.reload()
+	assert.True(t, before == loader.Template())
+
+	// a change to any file is picked up
+	writeTemplate(t, parts, `{{ define "greeting" }}hello{{ end }}`, start.Add(time.Minute))
+	loader.reload()
+	assert.Equal(t, "hello gopher!", execute(t, loader.Template()))
+
+	// a broken template is logged, and the last good one kept
+	writeTemplate(t, page, `{{ template "greeting" `, start.Add(2*time.Minute))
+	loader.reload()
+	assert.Equal(t, "hello gopher!", execute(t, loader.Template()))
+	assert.Contains(t, logs.String(), "keeping the last good one")
+
+	writeTemplate(t, page, `{{ template "greeting" }}, fixed`, start.Add(3*time.Minute))
+	loader.reload()
+	assert.Equal(t, "hello, fixed", execute(t, loader.Template()))
+}
+
+func TestLoadTemplates_errors(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+
+	_, err = LoadTemplates(logger, done, 0, nil, filepath.Join(tempdir, "*.html"))
+	assert.Error(t, err, "nothing matched")
+
+	bad := filepath.Join(tempdir, "bad.html")
+	writeTemplate(t, bad, `{{ if }}`, time.Now())
+	_, err = LoadTemplates(logger, done, 0, nil, bad)
+	assert.Error(t, err, "does not parse")
+}
+
+func TestIndex_withTemplates(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+
+	page := filepath.Join(tempdir, "index.template")
+	writeTemplate(t, page, `one {{ srcset "/a.jpg" }}`, time.Now().Add(-time.Hour))
+
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+	loader, err := LoadTemplates(logger, done, 10*time.Millisecond, nil, page)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(Index(logger, "testdata/sample_images", done, nil,
+		WithTemplates(loader), WithSrcset(Srcset{Widths: []int{10}, Pattern: "/t/{width}{path}"})))
+	defer ts.Close()
+
+	get := func() string {
+		res, err := http.Get(ts.URL)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		return string(body)
+	}
+	assert.Equal(t, "one /t/10/a.jpg 10w", get())
+
+	writeTemplate(t, page, `two {{ srcset "/a.jpg" }}`, time.Now())
+	deadline := time.Now().Add(5 * time.Second)
+	for get() != "two /t/10/a.jpg 10w" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, "two /t/10/a.jpg 10w", get())
+}
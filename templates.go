@@ -0,0 +1,49 @@
+package dandler
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+)
+
+//go:embed templates
+var builtinTemplates embed.FS
+
+// IndexTemplates are the names of the built-in templates for Index:
+//
+//	table    a plain table of files, with size and modification time
+//	gallery  a grid of images, using the functions from WithSrcset
+var IndexTemplates = []string{"table", "gallery"}
+
+// IndexTemplate returns a copy of one of the built-in templates for Index, by
+// name. The pages are built from blocks - "title", "head", "header",
+// "content" and "footer" - any of which can be replaced by parsing a
+// {{define}} of the same name into the returned template.
+//
+// Everything is self contained - no scripts or styles are loaded from
+// elsewhere.
+func IndexTemplate(name string) (*template.Template, error) {
+	found := false
+	for _, each := range IndexTemplates {
+		found = found || each == name
+	}
+	if !found {
+		return nil, fmt.Errorf("no built-in template named %q", name)
+	}
+	return template.New(name+".html").Funcs(IndexFuncs()).ParseFS(builtinTemplates,
+		"templates/"+name+".html", "templates/common.html")
+}
+
+// humanize formats a size in bytes for people to read.
+func humanize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
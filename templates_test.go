@@ -0,0 +1,95 @@
+package dandler
+
+import (
+	"image"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHumanize(t *testing.T) {
+	assert.Equal(t, "0 B", humanize(0))
+	assert.Equal(t, "1023 B", humanize(1023))
+	assert.Equal(t, "1.0 KiB", humanize(1024))
+	assert.Equal(t, "1.5 MiB", humanize(3<<19))
+}
+
+func TestIndexTemplate(t *testing.T) {
+	for _, name := range IndexTemplates {
+		_, err := IndexTemplate(name)
+		assert.NoError(t, err, name)
+	}
+	_, err := IndexTemplate("nope")
+	assert.Error(t, err)
+}
+
+func TestIndex_builtinTemplates(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	require.NoError(t, os.MkdirAll(filepath.Join(tempdir, "sub", "deeper"), 0755))
+	writeTestPNG(t, filepath.Join(tempdir, "sub", "<i>&x.png"), image.NewGray(image.Rect(0, 0, 4, 4)))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tempdir, "sub", "notes.txt"), []byte("ohai"), 0644))
+
+	gallery, err := IndexTemplate("gallery")
+	require.NoError(t, err)
+	overridden, err := IndexTemplate("table")
+	require.NoError(t, err)
+	_, err = overridden.Parse(`{{ define "title" }}My files{{ end }}`)
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	defer close(done)
+	logger := log.New(ioutil.Discard, "", 0)
+
+	get := func(t *testing.T, ts *httptest.Server) string {
+		res, err := http.Get(ts.URL + "/sub/")
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.NotContains(t, string(body), "<i>", "filenames must be escaped")
+		assert.NotContains(t, string(body), "://", "nothing should be loaded from elsewhere")
+		return string(body)
+	}
+
+	t.Run("default", func(t *testing.T) {
+		ts := httptest.NewServer(Index(logger, tempdir, done, nil))
+		defer ts.Close()
+		body := get(t, ts)
+		assert.Contains(t, body, "<title>Index of /sub</title>")
+		assert.Contains(t, body, `<a href="/">/</a><a href="/sub/">sub</a>/`)
+		assert.Contains(t, body, `<a href="/sub/deeper/">deeper/</a>`)
+		assert.Contains(t, body, `<a href="/sub/notes.txt">notes.txt</a>`)
+		assert.Contains(t, body, `<a href="/sub/%3Ci%3E&amp;x.png">&lt;i&gt;&amp;x.png</a>`)
+		assert.Contains(t, body, "4 B")
+	})
+
+	t.Run("override", func(t *testing.T) {
+		ts := httptest.NewServer(Index(logger, tempdir, done, overridden))
+		defer ts.Close()
+		assert.Contains(t, get(t, ts), "<title>My files</title>")
+	})
+
+	t.Run("gallery", func(t *testing.T) {
+		ts := httptest.NewServer(Index(logger, tempdir, done, gallery, WithSrcset(Srcset{
+			Widths:  []int{250},
+			Pattern: "/thumbs/{width}{path}.png",
+		})))
+		defer ts.Close()
+		body := get(t, ts)
+		assert.Contains(t, body, `<img src="/thumbs/250/sub/%3Ci%3E&amp;x.png.png"`)
+		assert.Contains(t, body, `width="4" height="4"`)
+		assert.Contains(t, body, `<a href="/sub/deeper/">deeper/</a>`)
+		assert.Equal(t, 1, strings.Count(body, "<figure>"), "only images belong in the grid")
+	})
+}
@@ -2,13 +2,13 @@ package dandler
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"log"
 	"net/http"
 	"os"
 	"path"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -20,20 +20,26 @@ import (
 	"image/png"
 
 	"github.com/golang/groupcache"
-	"github.com/nfnt/resize"
 	"github.com/oliamb/cutter"
 )
 
 // ThumbCache returns a handler that serves thumbnails from GroupCache.
-// Thumbnails are generated when needed by GroupCache.
+// Thumbnails are generated when needed by GroupCache. Generation is limited by
+// the WorkPool given with WithWorkPool, or DefaultWorkPool. There are no
+// thumbnails of images ignored with WithIgnore or WithIgnoreFile, or of
+// directories, and symlinks are only followed as WithSymlinks allows.
 func ThumbCache(logger *log.Logger, targetWidth, targetHeight int, cacheSize int64,
-	rawImageDirectory, cacheName, thumbnailExtension string) http.Handler {
+	rawImageDirectory, cacheName, thumbnailExtension string, opts ...Option) http.Handler {
+	o := buildOptions(opts)
 	this := thumbCache{
 		x:        targetWidth,
 		y:        targetHeight,
 		raw:      rawImageDirectory,
 		thumbExt: thumbnailExtension,
 		l:        logger,
+		pool:     o.pool,
+		ignore:   newIgnorer(http.Dir(rawImageDirectory), o),
+		symlinks: o.symlinks,
 	}
 	this.cache = groupcache.NewGroup(cacheName, cacheSize, this)
 	return this
@@ -47,13 +53,25 @@ type thumbCache struct {
 	raw      string
 	thumbExt string
 	l        *log.Logger
+	pool     *WorkPool
+	ignore   *ignorer
+	symlinks SymlinkPolicy
 	cache    *groupcache.Group
 }
 
 func (h thumbCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	raw, err := h.openRaw(r.URL.Path)
+	if err != nil {
+		failOpen(w, r, h.l, h.raw, r.URL.Path, err)
+		return
+	}
+	raw.Close()
+
 	w.Header().Set("Content-Type", "image/"+h.thumbExt)
-	data := new([]byte)
-	err := h.cache.Get(nil, r.URL.Path, groupcache.AllocatingByteSliceSink(data))
+	data, err := h.get(r.Context(), r.URL.Path)
+	if poolError(w, r, h.l, h.pool, err) {
+		return
+	}
 	if err != nil {
 		if strings.HasPrefix(err.Error(), "could not open image") {
 			h.l.Println(err)
@@ -64,12 +82,43 @@ func (h thumbCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	http.ServeContent(w, r, r.URL.Path, time.Now(), bytes.NewReader(*data))
+	http.ServeContent(w, r, r.URL.Path, time.Now(), bytes.NewReader(data))
+}
+
+// get fetches a thumbnail from the cache. Requests for the same thumbnail
+// share one generation, so it is not tied to any one of them - it runs until
+// done, or until the WorkPool's timeout. A request that goes away stops
+// waiting, and the thumbnail is still cached for the next one.
+func (h thumbCache) get(ctx context.Context, key string) ([]byte, error) {
+	type result struct {
+		data []byte
+		err  error
+	}
+	found := make(chan result, 1)
+	go func() {
+		var data []byte
+		err := h.cache.Get(context.Background(), key, groupcache.AllocatingByteSliceSink(&data))
+		found <- result{data: data, err: err}
+	}()
+	select {
+	case res := <-found:
+		return res.data, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
 func (h thumbCache) Get(ctx groupcache.Context, key string,
 	dest groupcache.Sink) error {
-	value, err := h.generateThumbnail(key)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var value []byte
+	err := h.pool.Do(ctx, func(ctx context.Context) error {
+		var genErr error
+		value, genErr = h.generateThumbnail(ctx, key)
+		return genErr
+	})
 	if err != nil {
 		return err
 	}
@@ -77,35 +126,49 @@ func (h thumbCache) Get(ctx groupcache.Context, key string,
 	return nil
 }
 
-func (h thumbCache) generateThumbnail(imageName string) ([]byte, error) {
-	rawImage, err := h.openImage(h.generateRawPath(imageName))
+// generateThumbnail checks ctx between each stage, and decode and encode check
+// it as they go - so an abandoned request stops using CPU quickly.
+func (h thumbCache) generateThumbnail(ctx context.Context, imageName string) ([]byte, error) {
+	rawImage, err := h.openImage(ctx, imageName)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return []byte{}, ctxErr
+	}
 	if err != nil {
 		return []byte{}, fmt.Errorf("could not open image [%s]: %s", imageName, err)
 	}
-	thumbImg, err := h.resizeImage(rawImage)
+	thumbImg, err := h.resizeImage(ctx, rawImage)
 	if err != nil {
-		return []byte{}, fmt.Errorf("cound not resize image [%s]: %v", imageName, err)
+		return []byte{}, fmt.Errorf("cound not resize image [%s]: %w", imageName, err)
 	}
-	data, err := h.encodeImage(thumbImg)
+	data, err := h.encodeImage(ctx, thumbImg)
 	return data, err
 }
 
-func (h thumbCache) openImage(imageName string) (image.Image, error) {
-	path := filepath.Clean(imageName)
-	reader, err := os.Open(path)
+// openRaw opens the image a thumbnail is made from, as long as the symlink
+// policy and ignore rules allow it.
+func (h thumbCache) openRaw(imageName string) (*os.File, error) {
+	f, _, err := openAllowedFile(h.raw, imageName, h.symlinks, h.ignore)
+	return f, err
+}
+
+func (h thumbCache) openImage(ctx context.Context, imageName string) (image.Image, error) {
+	reader, err := h.openRaw(imageName)
 	if err != nil {
 		return nil, err
 	}
 	defer reader.Close()
-	img, _, err := image.Decode(reader)
+	img, _, err := image.Decode(ctxReader{ctx: ctx, r: reader})
 	if err != nil {
 		return nil, err
 	}
 	return img, nil
 }
 
-func (h thumbCache) resizeImage(rawImage image.Image) (image.Image, error) {
-	shrunk := resize.Resize(0, uint(h.y), rawImage, resize.MitchellNetravali)
+func (h thumbCache) resizeImage(ctx context.Context, rawImage image.Image) (image.Image, error) {
+	shrunk, err := resizeHeight(ctx, uint(h.y), rawImage)
+	if err != nil {
+		return nil, err
+	}
 	thumbnail, err := cutter.Crop(shrunk, cutter.Config{
 		Height:  h.y,
 		Width:   h.x,
@@ -118,18 +181,26 @@ func (h thumbCache) resizeImage(rawImage image.Image) (image.Image, error) {
 	return thumbnail, nil
 }
 
-func (h thumbCache) encodeImage(img image.Image) ([]byte, error) {
+func (h thumbCache) encodeImage(ctx context.Context, img image.Image) ([]byte, error) {
 	buf := new(bytes.Buffer)
+	out := ctxWriter{ctx: ctx, w: buf}
+	var err error
 	switch h.thumbExt {
 	case "jpg":
-		jpeg.Encode(buf, img, nil)
+		err = jpeg.Encode(out, img, nil)
 	case "jpeg":
-		jpeg.Encode(buf, img, nil)
+		err = jpeg.Encode(out, img, nil)
 	case "png":
-		png.Encode(buf, img)
+		err = png.Encode(out, img)
 	default:
 		return []byte{}, fmt.Errorf("extension [%s] not supported", h.thumbExt)
 	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return []byte{}, ctxErr
+	}
+	if err != nil {
+		return []byte{}, err
+	}
 	return buf.Bytes(), nil
 }
 
@@ -1,8 +1,10 @@
 package dandler
 
 import (
+	"context"
 	"fmt"
 	"image"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -18,7 +20,6 @@ import (
 	"image/jpeg"
 	"image/png"
 
-	"github.com/nfnt/resize"
 	"github.com/oliamb/cutter"
 	"github.com/traherom/memstream"
 )
@@ -26,9 +27,13 @@ import (
 // Thumbnail returns a handler that generates a thumbnail of the given
 // size of each image, stores it in the specified location, and serves back the
 // thumbnails upon request. Thumbnails are generated when needed. File caching
-// is used to decrease thumbnail generation.
+// is used to decrease thumbnail generation. Generation is limited by the
+// WorkPool given with WithWorkPool, or DefaultWorkPool. There are no
+// thumbnails of images ignored with WithIgnore or WithIgnoreFile, or of
+// directories, and symlinks are only followed as WithSymlinks allows.
 func Thumbnail(logger *log.Logger, targetWidth, targetHeight int,
-	rawImageDirectory, thumbnailDirectory, thumbnailExtension string) http.Handler {
+	rawImageDirectory, thumbnailDirectory, thumbnailExtension string, opts ...Option) http.Handler {
+	o := buildOptions(opts)
 	return thumbnailHandler{
 		x:        targetWidth,
 		y:        targetHeight,
@@ -36,6 +41,9 @@ func Thumbnail(logger *log.Logger, targetWidth, targetHeight int,
 		thumbs:   thumbnailDirectory,
 		thumbExt: thumbnailExtension,
 		l:        logger,
+		pool:     o.pool,
+		ignore:   newIgnorer(http.Dir(rawImageDirectory), o),
+		symlinks: o.symlinks,
 	}
 }
 
@@ -46,9 +54,19 @@ type thumbnailHandler struct {
 	thumbs   string
 	thumbExt string
 	l        *log.Logger
+	pool     *WorkPool
+	ignore   *ignorer
+	symlinks SymlinkPolicy
 }
 
 func (h thumbnailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	raw, err := h.openRaw(h.trimThumbExt(r.URL.Path))
+	if err != nil {
+		failOpen(w, r, h.l, h.raw, h.trimThumbExt(r.URL.Path), err)
+		return
+	}
+	raw.Close()
+
 	f, err := os.Open(h.generateThumbPath(h.trimThumbExt(r.URL.Path)))
 	if err == nil {
 		defer f.Close()
@@ -66,7 +84,14 @@ func (h thumbnailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var img image.Image
-	img, err = h.loadThumbnail(h.trimThumbExt(r.URL.Path))
+	err = h.pool.Do(r.Context(), func(ctx context.Context) error {
+		var loadErr error
+		img, loadErr = h.loadThumbnail(ctx, h.trimThumbExt(r.URL.Path))
+		return loadErr
+	})
+	if poolError(w, r, h.l, h.pool, err) {
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("cannot read file: %s", r.URL.Path), http.StatusNotFound)
 		h.l.Printf("500 - error opening file: %s - %s", filepath.Join(h.thumbs, r.URL.Path), err)
@@ -74,39 +99,49 @@ func (h thumbnailHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	buf := memstream.NewCapacity(1000000)
+	out := ctxWriter{ctx: r.Context(), w: buf}
 	// rewrite to just generate an Encoder, and use that later maybe instead?
 	w.Header().Set("Content-Type", "image/"+h.thumbExt)
 	switch h.thumbExt {
 	case "jpg":
-		jpeg.Encode(buf, img, nil)
+		jpeg.Encode(out, img, nil)
 	case "jpeg":
-		jpeg.Encode(buf, img, nil)
+		jpeg.Encode(out, img, nil)
 	case "png":
-		png.Encode(buf, img)
+		png.Encode(out, img)
 	default:
 		http.Error(w, fmt.Sprintf("could not respond with file; %s", r.URL.Path), http.StatusInternalServerError)
 		h.l.Printf("500 - error pushing thumbnail: %s - %s", filepath.Join(h.thumbs, r.URL.Path), err)
 		return
 	}
 
+	if poolError(w, r, h.l, h.pool, r.Context().Err()) {
+		return
+	}
+
 	buf.Rewind()
 	http.ServeContent(w, r, r.URL.Path, time.Now(), buf)
 }
 
-func (h thumbnailHandler) loadThumbnail(imageName string) (image.Image, error) {
-	img, format, err := h.openImage(h.generateThumbPath(imageName))
+// loadThumbnail checks ctx between each stage, and decode and encode check it
+// as they go - so an abandoned request stops using CPU quickly.
+func (h thumbnailHandler) loadThumbnail(ctx context.Context, imageName string) (image.Image, error) {
+	img, format, err := h.openImage(ctx, h.generateThumbPath(imageName))
 	if os.IsNotExist(err) || format != h.thumbExt {
-		img, _, err = h.openImage(h.generateRawPath(imageName))
+		img, err = h.openRawImage(ctx, imageName)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
 		if err != nil {
 			return nil, fmt.Errorf("could not open image [%s]: %s", imageName, err)
 		}
-		img, err = h.generateThumbnail(img)
+		img, err = h.generateThumbnail(ctx, img)
 		if err != nil {
-			return nil, fmt.Errorf("could not process [%s]: %s", imageName, err)
+			return nil, fmt.Errorf("could not process [%s]: %w", imageName, err)
 		}
-		err = h.writeThumbnail(imageName, img)
+		err = h.writeThumbnail(ctx, imageName, img)
 		if err != nil {
-			return nil, fmt.Errorf("could not cache thumbnail [%s]: %s", imageName, err)
+			return nil, fmt.Errorf("could not cache thumbnail [%s]: %w", imageName, err)
 		}
 	}
 	if err != nil {
@@ -115,30 +150,52 @@ func (h thumbnailHandler) loadThumbnail(imageName string) (image.Image, error) {
 	return img, nil
 }
 
-func (h thumbnailHandler) writeThumbnail(imageName string, thumbnailImage image.Image) error {
+// writeThumbnail encodes to a temporary file, and only moves it into place once
+// complete - so a cancelled request never leaves a partial thumbnail behind.
+func (h thumbnailHandler) writeThumbnail(ctx context.Context, imageName string, thumbnailImage image.Image) error {
 	err := os.MkdirAll(filepath.Join(h.thumbs, "/", filepath.Dir(imageName)), 755)
 	if err != nil {
 		return fmt.Errorf("could not create folder [%s]: %s", imageName, err)
 	}
-	out, err := os.Create(h.generateThumbPath(imageName))
+	target := h.generateThumbPath(imageName)
+	out, err := ioutil.TempFile(filepath.Dir(target), ".thumb-")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(out.Name())
 	defer out.Close()
+
+	dest := ctxWriter{ctx: ctx, w: out}
 	switch h.thumbExt {
 	case "jpg":
-		return jpeg.Encode(out, thumbnailImage, nil)
+		err = jpeg.Encode(dest, thumbnailImage, nil)
 	case "jpeg":
-		return jpeg.Encode(out, thumbnailImage, nil)
+		err = jpeg.Encode(dest, thumbnailImage, nil)
 	case "png":
-		return png.Encode(out, thumbnailImage)
+		err = png.Encode(dest, thumbnailImage)
 	default:
 		return fmt.Errorf("extension type [%s] not supported for thumbnails", h.thumbExt)
 	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(out.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), target)
 }
 
-func (h thumbnailHandler) generateThumbnail(rawImage image.Image) (image.Image, error) {
-	shrunk := resize.Resize(0, uint(h.y), rawImage, resize.MitchellNetravali)
+func (h thumbnailHandler) generateThumbnail(ctx context.Context, rawImage image.Image) (image.Image, error) {
+	shrunk, err := resizeHeight(ctx, uint(h.y), rawImage)
+	if err != nil {
+		return nil, err
+	}
 	thumbnail, err := cutter.Crop(shrunk, cutter.Config{
 		Height:  h.y,
 		Width:   h.x,
@@ -151,20 +208,37 @@ func (h thumbnailHandler) generateThumbnail(rawImage image.Image) (image.Image,
 	return thumbnail, nil
 }
 
-func (h thumbnailHandler) openImage(imageName string) (image.Image, string, error) {
+func (h thumbnailHandler) openImage(ctx context.Context, imageName string) (image.Image, string, error) {
 	path := filepath.Clean(imageName)
 	reader, err := os.Open(path)
 	if err != nil {
 		return nil, "", err
 	}
 	defer reader.Close()
-	img, format, err := image.Decode(reader)
+	img, format, err := image.Decode(ctxReader{ctx: ctx, r: reader})
 	if err != nil {
 		return nil, "", err
 	}
 	return img, format, nil
 }
 
+// openRaw opens the image a thumbnail is made from, as long as the symlink
+// policy and ignore rules allow it.
+func (h thumbnailHandler) openRaw(imageName string) (*os.File, error) {
+	f, _, err := openAllowedFile(h.raw, imageName, h.symlinks, h.ignore)
+	return f, err
+}
+
+func (h thumbnailHandler) openRawImage(ctx context.Context, imageName string) (image.Image, error) {
+	reader, err := h.openRaw(imageName)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	img, _, err := image.Decode(ctxReader{ctx: ctx, r: reader})
+	return img, err
+}
+
 func (h thumbnailHandler) generateThumbPath(imageName string) string {
 	return path.Clean(fmt.Sprintf("%s/%s.%s", h.thumbs, imageName, h.thumbExt))
 }
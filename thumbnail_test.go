@@ -1,6 +1,7 @@
 package dandler
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -53,7 +54,7 @@ func TestLoadThumbnail(t *testing.T) {
 	h := thumbnailHandler{x: 200, y: 200, raw: "testdata", thumbExt: "png", thumbs: tempdir}
 
 	for id, test := range testData {
-		h.loadThumbnail(test.imageName)
+		h.loadThumbnail(context.Background(), test.imageName)
 		info, err := os.Stat(h.generateThumbPath(test.imageName))
 		if err != nil {
 			t.Logf("#%d - failed to stat thumbnail [%s] tempdir [%s]: %s",
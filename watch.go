@@ -0,0 +1,190 @@
+package dandler
+
+import (
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/rjeczalik/notify"
+)
+
+// These are the kinds of changes reported by a watcher.
+const (
+	changeAdd    = "add"
+	changeRemove = "remove"
+	changeRename = "rename"
+	changeWrite  = "write"
+)
+
+// change is a single filesystem event under a watched location. Path is
+// relative to the watched location, slash separated, with a leading slash -
+// the same form dir.Tracker uses.
+type change struct {
+	Op   string
+	Path string
+}
+
+// watcher recursively watches a location and passes every change to the
+// registered callbacks. dir.Tracker keeps a list of directories, but does not
+// tell anyone when that list changes - this does.
+type watcher struct {
+	basepath string
+	events   chan notify.EventInfo
+
+	quit chan struct{}
+
+	lock    sync.Mutex
+	nextID  int
+	subs    map[int]func(change)
+	stopped bool
+}
+
+// watchChanges starts watching basepath and everything under it. The watch
+// stops when done is closed.
+func watchChanges(basepath string, done <-chan struct{}) (*watcher, error) {
+	abs, err := filepath.Abs(basepath)
+	if err != nil {
+		return nil, err
+	}
+	abs, err = filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &watcher{
+		basepath: abs,
+		events:   make(chan notify.EventInfo, 64),
+		quit:     make(chan struct{}),
+		subs:     make(map[int]func(change)),
+	}
+	if err := notify.Watch(filepath.Join(abs, "..."), w.events, notify.All); err != nil {
+		return nil, err
+	}
+
+	go w.run()
+	go func() {
+		<-done
+		w.stop()
+	}()
+	return w, nil
+}
+
+// subscribe registers fn to be called for every change. fn is called from the
+// watcher's goroutine, so it should not block. The returned func removes the
+// subscription.
+func (w *watcher) subscribe(fn func(change)) func() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	id := w.nextID
+	w.nextID++
+	w.subs[id] = fn
+	return func() {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		delete(w.subs, id)
+	}
+}
+
+// subscribeQueued is subscribe for work that may block - such as reading the
+// files that changed. fn is called from a goroutine of its own, with changes
+// queued up in the meantime. Changes to the same path are only passed on once,
+// so a burst of changes does not pile up.
+func (w *watcher) subscribeQueued(fn func(change)) func() {
+	q := &changeQueue{pending: make(map[string]change), wake: make(chan struct{}, 1)}
+	go q.run(w.quit, fn)
+	return w.subscribe(q.add)
+}
+
+// changeQueue holds changes until they are handled, one per path.
+type changeQueue struct {
+	lock    sync.Mutex
+	order   []string
+	pending map[string]change
+	wake    chan struct{}
+}
+
+// add queues c - it never blocks.
+func (q *changeQueue) add(c change) {
+	q.lock.Lock()
+	if queued, ok := q.pending[c.Path]; !ok {
+		q.order = append(q.order, c.Path)
+		q.pending[c.Path] = c
+	} else if queued.Op != changeAdd && queued.Op != changeRename {
+		// a path that appeared still needs to be walked, whatever followed
+		q.pending[c.Path] = c
+	}
+	q.lock.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *changeQueue) run(quit <-chan struct{}, fn func(change)) {
+	for {
+		select {
+		case <-quit:
+			return
+		case <-q.wake:
+		}
+
+		q.lock.Lock()
+		order, pending := q.order, q.pending
+		q.order, q.pending = nil, make(map[string]change)
+		q.lock.Unlock()
+
+		for _, p := range order {
+			fn(pending[p])
+		}
+	}
+}
+
+func (w *watcher) run() {
+	for e := range w.events {
+		c := change{Path: w.relative(e.Path())}
+		switch e.Event() {
+		case notify.Create:
+			c.Op = changeAdd
+		case notify.Remove:
+			c.Op = changeRemove
+		case notify.Rename:
+			c.Op = changeRename
+		case notify.Write:
+			c.Op = changeWrite
+		default:
+			continue
+		}
+
+		w.lock.Lock()
+		subs := make([]func(change), 0, len(w.subs))
+		for _, fn := range w.subs {
+			subs = append(subs, fn)
+		}
+		w.lock.Unlock()
+
+		for _, fn := range subs {
+			fn(c)
+		}
+	}
+}
+
+func (w *watcher) relative(p string) string {
+	rel, err := filepath.Rel(w.basepath, p)
+	if err != nil {
+		return path.Clean("/" + filepath.ToSlash(p))
+	}
+	return path.Clean("/" + filepath.ToSlash(rel))
+}
+
+func (w *watcher) stop() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.stopped {
+		return
+	}
+	w.stopped = true
+	close(w.quit)
+	notify.Stop(w.events)
+	close(w.events)
+}
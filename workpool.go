@@ -0,0 +1,118 @@
+package dandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// ErrSaturated is returned by WorkPool.Do when every worker is busy and the
+// queue is full.
+var ErrSaturated = errors.New("work pool saturated")
+
+// DefaultWorkPool is shared by every image handler that is not given a pool
+// with WithWorkPool. It allows one job per CPU, with a few more queued.
+var DefaultWorkPool = NewWorkPool(runtime.NumCPU(), 4*runtime.NumCPU(), 30*time.Second)
+
+// WorkPool limits how many expensive jobs - such as decoding and resizing
+// images - run at once. Jobs beyond the number of workers wait in a queue of
+// limited depth, and jobs beyond that are rejected outright.
+type WorkPool struct {
+	workers chan struct{}
+	queue   chan struct{}
+	timeout time.Duration
+}
+
+// NewWorkPool creates a WorkPool allowing the given number of concurrent jobs,
+// with up to queue jobs waiting for a free worker. If timeout is more than 0,
+// each job - including time spent in the queue - is cancelled after timeout.
+func NewWorkPool(workers, queue int, timeout time.Duration) *WorkPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queue < 0 {
+		queue = 0
+	}
+	return &WorkPool{
+		workers: make(chan struct{}, workers),
+		queue:   make(chan struct{}, queue),
+		timeout: timeout,
+	}
+}
+
+// Do runs job once a worker is free, passing it a context that is cancelled
+// when ctx is, or when the pool's timeout is reached. If the queue is full,
+// ErrSaturated is returned without running job. A nil WorkPool runs job
+// immediately.
+func (p *WorkPool) Do(ctx context.Context, job func(context.Context) error) error {
+	if p == nil {
+		return job(ctx)
+	}
+
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	select {
+	case p.workers <- struct{}{}:
+	default:
+		// no free worker, so wait in line - if there is room in line
+		select {
+		case p.queue <- struct{}{}:
+		default:
+			return ErrSaturated
+		}
+		select {
+		case p.workers <- struct{}{}:
+			<-p.queue
+		case <-ctx.Done():
+			<-p.queue
+			return ctx.Err()
+		}
+	}
+	defer func() { <-p.workers }()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return job(ctx)
+}
+
+// retryAfter is the number of seconds a client should wait before trying again.
+func (p *WorkPool) retryAfter() int {
+	if p == nil || p.timeout < time.Second {
+		return 1
+	}
+	return int(p.timeout / time.Second)
+}
+
+// poolError responds to a request that could not be completed by a WorkPool.
+// It returns false if err did not come from the pool.
+func poolError(w http.ResponseWriter, r *http.Request, l *log.Logger, p *WorkPool, err error) bool {
+	switch {
+	case errors.Is(err, ErrSaturated):
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", p.retryAfter()))
+		http.Error(w, fmt.Sprintf("server busy: %s", r.URL.Path), http.StatusServiceUnavailable)
+		l.Printf("503 - work pool saturated: %s", r.URL.Path)
+	case errors.Is(err, context.DeadlineExceeded):
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", p.retryAfter()))
+		http.Error(w, fmt.Sprintf("timed out: %s", r.URL.Path), http.StatusServiceUnavailable)
+		l.Printf("503 - timed out: %s", r.URL.Path)
+	case errors.Is(err, context.Canceled) && r.Context().Err() != nil:
+		l.Printf("499 - client went away: %s", r.URL.Path)
+	case errors.Is(err, context.Canceled):
+		// the work was shared with a request that went away - this one did not
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", p.retryAfter()))
+		http.Error(w, fmt.Sprintf("server busy: %s", r.URL.Path), http.StatusServiceUnavailable)
+		l.Printf("503 - shared work cancelled: %s", r.URL.Path)
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package dandler
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// occupy fills every worker in the pool until the returned func is called.
+func occupy(t *testing.T, pool *WorkPool, workers int) func() {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		go pool.Do(context.Background(), func(context.Context) error {
+			started <- struct{}{}
+			<-release
+			return nil
+		})
+		<-started
+	}
+	return func() { close(release) }
+}
+
+func TestWorkPool(t *testing.T) {
+	pool := NewWorkPool(1, 1, 0)
+	release := occupy(t, pool, 1)
+
+	// one job can wait in the queue, the next is rejected
+	queued := make(chan error)
+	go func() {
+		queued <- pool.Do(context.Background(), func(context.Context) error { return nil })
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	err := pool.Do(context.Background(), func(context.Context) error {
+		t.Error("job should not run on a saturated pool")
+		return nil
+	})
+	assert.Equal(t, ErrSaturated, err)
+
+	release()
+	assert.NoError(t, <-queued)
+
+	// once idle, jobs run and their errors are passed back
+	jobErr := errors.New("job failed")
+	assert.Equal(t, jobErr, pool.Do(context.Background(), func(context.Context) error { return jobErr }))
+}
+
+func TestWorkPool_cancel(t *testing.T) {
+	pool := NewWorkPool(1, 4, 0)
+	release := occupy(t, pool, 1)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := pool.Do(ctx, func(context.Context) error {
+		t.Error("job should not run after the request is cancelled")
+		return nil
+	})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestWorkPool_timeout(t *testing.T) {
+	pool := NewWorkPool(1, 4, 50*time.Millisecond)
+	release := occupy(t, pool, 1)
+	defer release()
+
+	err := pool.Do(context.Background(), func(context.Context) error { return nil })
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 1, pool.retryAfter())
+}
+
+func TestWorkPool_nil(t *testing.T) {
+	var pool *WorkPool
+	ran := false
+	assert.NoError(t, pool.Do(context.Background(), func(context.Context) error {
+		ran = true
+		return nil
+	}))
+	assert.True(t, ran)
+}
+
+func TestWorkPool_saturatedHandlers(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "dandler-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+
+	pool := NewWorkPool(1, 0, 5*time.Second)
+	release := occupy(t, pool, 1)
+	defer release()
+
+	logger := log.New(ioutil.Discard, "", 0)
+	for name, handler := range map[string]http.Handler{
+		"Thumbnail": Thumbnail(logger, 300, 250, "./testdata/", tempdir, "png", WithWorkPool(pool)),
+		"ThumbCache": ThumbCache(logger, 300, 250, 64<<20, "./testdata/",
+			"test-saturated", "png", WithWorkPool(pool)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts := httptest.NewServer(handler)
+			defer ts.Close()
+
+			uri := "/blocked_us.png"
+			if name == "Thumbnail" {
+				uri += ".png"
+			}
+			res, err := http.Get(ts.URL + uri)
+			require.NoError(t, err)
+			res.Body.Close()
+			assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+			assert.Equal(t, "5", res.Header.Get("Retry-After"))
+		})
+	}
+}
+
+func TestPoolError_cancelled(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	pool := NewWorkPool(1, 0, 0)
+
+	// work shared with a request that went away - this one is still waiting
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/blocked_us.png", nil)
+	assert.True(t, poolError(w, r, logger, pool, context.Canceled))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// this request went away itself, so nothing is sent
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = httptest.NewRecorder()
+	assert.True(t, poolError(w, r.WithContext(ctx), logger, pool, context.Canceled))
+	assert.Empty(t, w.Body.String())
+}